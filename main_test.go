@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/fortnoxab/renovator/pkg/master"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli/v2"
+)
+
+func TestDryRunCanaryFlags(t *testing.T) {
+	a := app()
+	var m *master.Master
+	a.Command("dryrun").Action = func(ctx *cli.Context) error {
+		var err error
+		m, err = master.NewMasterFromContext(ctx)
+		return err
+	}
+
+	err := a.Run([]string{"renovator", "dryrun", "--redis-url", "redis://localhost:6379", "--batch", "batch1", "--canary-percent", "5"})
+	assert.NoError(t, err)
+	assert.True(t, m.Router.Canary.Enabled())
+}
@@ -2,7 +2,11 @@
 
 package mocks
 
-import mock "github.com/stretchr/testify/mock"
+import (
+	io "io"
+
+	mock "github.com/stretchr/testify/mock"
+)
 
 // MockCommander is an autogenerated mock type for the Commander type
 type MockCommander struct {
@@ -140,6 +144,69 @@ func (_c *MockCommander_RunWithEnv_Call) RunAndReturn(run func([]string, string,
 	return _c
 }
 
+// RunWithOutput provides a mock function with given fields: output, env, head, parts
+func (_m *MockCommander) RunWithOutput(output io.Writer, env []string, head string, parts ...string) error {
+	_va := make([]interface{}, len(parts))
+	for _i := range parts {
+		_va[_i] = parts[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, output, env, head)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RunWithOutput")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(io.Writer, []string, string, ...string) error); ok {
+		r0 = rf(output, env, head, parts...)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockCommander_RunWithOutput_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'RunWithOutput'
+type MockCommander_RunWithOutput_Call struct {
+	*mock.Call
+}
+
+// RunWithOutput is a helper method to define mock.On call
+//   - output io.Writer
+//   - env []string
+//   - head string
+//   - parts ...string
+func (_e *MockCommander_Expecter) RunWithOutput(output interface{}, env interface{}, head interface{}, parts ...interface{}) *MockCommander_RunWithOutput_Call {
+	return &MockCommander_RunWithOutput_Call{Call: _e.mock.On("RunWithOutput",
+		append([]interface{}{output, env, head}, parts...)...)}
+}
+
+func (_c *MockCommander_RunWithOutput_Call) Run(run func(output io.Writer, env []string, head string, parts ...string)) *MockCommander_RunWithOutput_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		variadicArgs := make([]string, len(args)-3)
+		for i, a := range args[3:] {
+			if a != nil {
+				variadicArgs[i] = a.(string)
+			}
+		}
+		run(args[0].(io.Writer), args[1].([]string), args[2].(string), variadicArgs...)
+	})
+	return _c
+}
+
+func (_c *MockCommander_RunWithOutput_Call) Return(_a0 error) *MockCommander_RunWithOutput_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockCommander_RunWithOutput_Call) RunAndReturn(run func(io.Writer, []string, string, ...string) error) *MockCommander_RunWithOutput_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // NewMockCommander creates a new instance of MockCommander. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
 // The first argument is typically a *testing.T value.
 func NewMockCommander(t interface {
@@ -2,15 +2,35 @@ package agent
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/fortnoxab/renovator/pkg/blob"
+	"github.com/fortnoxab/renovator/pkg/canary"
 	"github.com/fortnoxab/renovator/pkg/command"
+	"github.com/fortnoxab/renovator/pkg/githubapp"
+	"github.com/fortnoxab/renovator/pkg/history"
+	"github.com/fortnoxab/renovator/pkg/limit"
+	"github.com/fortnoxab/renovator/pkg/logstore"
+	"github.com/fortnoxab/renovator/pkg/overrides"
+	"github.com/fortnoxab/renovator/pkg/platform"
 	localredis "github.com/fortnoxab/renovator/pkg/redis"
+	"github.com/fortnoxab/renovator/pkg/registry"
 	"github.com/fortnoxab/renovator/pkg/renovate"
+	"github.com/fortnoxab/renovator/pkg/repocache"
+	"github.com/fortnoxab/renovator/pkg/secrets"
 	"github.com/fortnoxab/renovator/pkg/webserver"
+	"github.com/fortnoxab/renovator/pkg/workspace"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
@@ -20,17 +40,92 @@ import (
 var renovateRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
 	Name: "renovate_runs",
 	Help: "Number of renovate runs",
-}, []string{"result", "repo"})
+}, []string{"result", "repo", "version"})
+
+var pullRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "renovate_pull_requests",
+	Help: "Number of pull requests created or updated by renovate",
+}, []string{"action"})
+
+var workspaceSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Name:    "renovator_workspace_bytes",
+	Help:    "Disk usage of the workspace of a renovate run when it finished",
+	Buckets: prometheus.ExponentialBuckets(1<<20, 4, 10),
+})
+
+var cacheSize = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "renovator_cache_bytes",
+	Help: "Disk usage of the cache shared between renovate runs",
+})
+
+var cacheEvicted = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "renovator_cache_evicted_bytes",
+	Help: "Number of bytes evicted from the shared cache",
+})
+
+var repoCacheRestores = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "renovator_repo_cache_restores",
+	Help: "Number of repository cache restores before renovate runs by result hit, miss or error",
+}, []string{"result"})
 
 func init() {
-	prometheus.MustRegister(renovateRuns)
+	prometheus.MustRegister(renovateRuns, pullRequests, workspaceSize, cacheSize, cacheEvicted, repoCacheRestores)
 }
 
+// defaultHeartbeatInterval is used if Agent.HeartbeatInterval is not set. The registration expires after 3 missed heartbeats.
+const defaultHeartbeatInterval = 10 * time.Second
+
+// streamLines is the number of lines of output kept for clients connecting to the live stream of a run.
+const streamLines = 1000
+
 type Agent struct {
+	ID      string
+	Version string
+	// URL is where the agent webserver can be reached by the master.
+	URL             string
 	Renovator       *renovate.Runner
 	RedisClient     redis.Cmdable
 	MaxProcessCount int
 	Webserver       *webserver.Webserver
+	LogStore        *logstore.Store
+	// LogMaxSize is the maximum number of bytes stored from the end of the output of a run.
+	LogMaxSize        int
+	HeartbeatInterval time.Duration
+	// Labels are the capabilities of the agent. It only runs jobs requiring a subset of them.
+	Labels []string
+	// ProjectWeights is the number of jobs in a row taken from a project when queues are partitioned per project.
+	ProjectWeights map[string]int
+	// ProjectLimits caps the number of running jobs per project across all agents, "*" applies to all other projects.
+	ProjectLimits map[string]int
+	// ClusterMaxRunning caps the number of running jobs across all agents.
+	ClusterMaxRunning int
+	// MaxStartsPerMinute limits the rate of job starts across all agents.
+	MaxStartsPerMinute int
+	// Workspaces creates a directory per run and evicts the shared cache, renovate defaults are used if nil.
+	Workspaces *workspace.Manager
+	// OverrideAllowlist is the renovate options that can be overridden per repo or project, overrides are not used if empty.
+	OverrideAllowlist overrides.Allowlist
+	// Secrets are resolved per project when a job starts and only given to its renovate process.
+	Secrets secrets.Source
+	// Platforms have the credentials of jobs on other platforms than the one configured in the environment.
+	Platforms []platform.Platform
+	// DefaultPlatform and DefaultEndpoint are RENOVATE_PLATFORM and RENOVATE_ENDPOINT of the agent, renovate uses them
+	// for jobs without a platform. Renovate defaults to github if the platform is empty.
+	DefaultPlatform string
+	DefaultEndpoint string
+	// GitHubApp mints the RENOVATE_TOKEN of each run as an installation token of the app on the owner of the repo.
+	GitHubApp *githubapp.App
+	// RepoCache shares the renovate repository cache between agents, it requires Workspaces.
+	RepoCache *repocache.Store
+	// AutoTune lowers the number of simultaneous processes below MaxProcessCount when the host is overloaded.
+	AutoTune bool
+	// CanaryGroup is canary or stable, finished runs are counted per group so the master can compare their error
+	// rates. Runs are not counted if empty.
+	CanaryGroup string
+
+	running     runningJobs
+	workers     pool
+	concurrency concurrency
 }
 
 func NewAgentFromContext(cCtx *cli.Context) (*Agent, error) {
@@ -39,16 +134,133 @@ func NewAgentFromContext(cCtx *cli.Context) (*Agent, error) {
 		return nil, fmt.Errorf("error parsing redis url, err: %w", err)
 	}
 	rc := redis.NewClient(opt)
-	return &Agent{
-		Renovator:       renovate.NewRunner(&command.Exec{}),
+
+	id := cCtx.String("agent-id")
+	if id == "" {
+		id, err = os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("error getting hostname for agent id, err: %w", err)
+		}
+	}
+
+	logStore, err := logstore.NewFromContext(cCtx)
+	if err != nil {
+		return nil, fmt.Errorf("error creating log store, err: %w", err)
+	}
+
+	platforms, err := platform.LoadFromContext(cCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	githubApp, err := githubapp.NewFromContext(cCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	repoCache, err := repocache.NewFromContext(cCtx)
+	if err != nil {
+		return nil, fmt.Errorf("error creating repository cache store, err: %w", err)
+	}
+
+	memory, err := command.ParseBytes(cCtx.String("child-memory-limit"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing --child-memory-limit, err: %w", err)
+	}
+	limits := command.Limits{
+		Memory:     memory,
+		CPU:        cCtx.Float64("child-cpu-limit"),
+		CgroupRoot: cCtx.String("child-cgroup-root"),
+	}
+	if (limits.Memory > 0 || limits.CPU > 0) && limits.CgroupRoot == "" {
+		logrus.Warn("--child-memory-limit and --child-cpu-limit require --child-cgroup-root, they are ignored")
+	}
+
+	cacheMaxSize, err := command.ParseBytes(cCtx.String("cache-max-size"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing --cache-max-size, err: %w", err)
+	}
+
+	weights, err := parseProjectValues(cCtx.StringSlice("project-weights"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing --project-weights, err: %w", err)
+	}
+	projectLimits, err := parseProjectValues(cCtx.StringSlice("project-max-running"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing --project-max-running, err: %w", err)
+	}
+
+	url := cCtx.String("advertise-url")
+	if url == "" {
+		url = "http://" + id + ":" + cCtx.String("port")
+	}
+
+	renovator, err := renovate.NewRunnerFromContext(cCtx, &command.Exec{Limits: limits})
+	if err != nil {
+		return nil, err
+	}
+	// jobs on an installed renovate version require its label
+	labels := cCtx.StringSlice("labels")
+	for _, v := range slices.Sorted(maps.Keys(renovator.Versions)) {
+		labels = append(labels, renovate.VersionLabel(v))
+	}
+
+	canaryGroup := canary.GroupStable
+	if slices.Contains(labels, cCtx.String("canary-label")) {
+		canaryGroup = canary.GroupCanary
+	}
+
+	var workspaces *workspace.Manager
+	if dir := cCtx.String("workspace-dir"); dir != "" {
+		workspaces = &workspace.Manager{
+			Dir:          dir,
+			ID:           id,
+			CacheDir:     cCtx.String("cache-dir"),
+			CacheMaxSize: cacheMaxSize,
+		}
+	}
+
+	a := &Agent{
+		ID:              id,
+		Version:         cCtx.App.Version,
+		URL:             url,
+		Renovator:       renovator,
 		RedisClient:     rc,
 		MaxProcessCount: cCtx.Int("max-process-count"),
-		Webserver:       &webserver.Webserver{Port: cCtx.String("port"), EnableMetrics: true},
-	}, nil
+		Webserver:       &webserver.Webserver{Port: cCtx.String("port"), EnableMetrics: true, AdminToken: cCtx.String("admin-token")},
+		LogStore:        logStore,
+		LogMaxSize:      cCtx.Int("log-max-size"),
+
+		HeartbeatInterval: cCtx.Duration("heartbeat-interval"),
+		Labels:            labels,
+		ProjectWeights:    weights,
+		ProjectLimits:     projectLimits,
+
+		ClusterMaxRunning:  cCtx.Int("cluster-max-running"),
+		MaxStartsPerMinute: cCtx.Int("max-starts-per-minute"),
+		AutoTune:           cCtx.Bool("auto-tune"),
+		Workspaces:         workspaces,
+		RepoCache:          repoCache,
+		OverrideAllowlist:  cCtx.StringSlice("override-allow"),
+		Secrets:            secrets.NewFromContext(cCtx),
+		GitHubApp:          githubApp,
+		Platforms:          platforms,
+		DefaultPlatform:    os.Getenv("RENOVATE_PLATFORM"),
+		DefaultEndpoint:    os.Getenv("RENOVATE_ENDPOINT"),
+		CanaryGroup:        canaryGroup,
+	}
+	a.Webserver.Routes = a.routes
+	a.Webserver.AdminRoutes = a.adminRoutes
+	return a, nil
 }
 
 func (a *Agent) Run(ctx context.Context) {
-	reposToProcess := make(chan string)
+	a.SetConcurrency(a.MaxProcessCount)
+	if a.Workspaces != nil {
+		if err := a.Workspaces.Cleanup(); err != nil {
+			logrus.Error(err)
+		}
+	}
 
 	wg := &sync.WaitGroup{}
 	if a.Webserver != nil {
@@ -59,33 +271,46 @@ func (a *Agent) Run(ctx context.Context) {
 		}()
 	}
 
-	for i := 0; i < a.MaxProcessCount; i++ {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		a.heartbeat(ctx)
+	}()
+
+	if a.LogStore != nil {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for {
-
-				select {
-				case <-ctx.Done():
-					return
-				case repo := <-reposToProcess:
-					logrus.Infof("running renovate on repo: %s", repo)
-					start := time.Now()
-					err := a.Renovator.RunRenovate(repo)
-					if err != nil {
-						renovateRuns.WithLabelValues("error", repo).Inc()
-						logrus.Errorf("error renovating repo: %s err: %s", repo, err)
-						continue
-					}
-					renovateRuns.WithLabelValues("ok", repo).Inc()
-					logrus.Infof("finished renovating repo: %s in %s", repo, time.Since(start))
-				}
-			}
+			a.LogStore.CleanupEvery(ctx, time.Hour)
 		}()
 	}
 
-	for ctx.Err() == nil {
-		repos, err := a.RedisClient.BLPop(ctx, time.Second*5, localredis.RedisRepoListKey).Result() // 0 duration == block until key exists. We block for 5 seconds otherwise it will not work with shutdown https://github.com/redis/go-redis/issues/2556
+	sched := newScheduler(a.ID, a.Labels, a.ProjectWeights)
+	for ctx.Err() == nil && a.workers.wait(ctx) {
+		paused, err := localredis.Paused(ctx, a.RedisClient)
+		if err != nil {
+			logrus.Error(err)
+		}
+		if slices.Contains(paused, localredis.PauseAll) {
+			logrus.Debug("all projects are paused")
+			sleep(ctx, pausedDelay)
+			continue
+		}
+		sched.paused = paused
+
+		keys, err := localredis.QueueKeys(ctx, a.RedisClient)
+		if err != nil {
+			logrus.Error(err)
+			keys = []string{localredis.RedisRepoListKey}
+		}
+
+		queues := sched.order(keys, time.Now())
+		if len(queues) == 0 {
+			sleep(ctx, deferDelay)
+			continue
+		}
+
+		repos, err := a.RedisClient.BLPop(ctx, time.Second*5, queues...).Result() // 0 duration == block until key exists. We block for 5 seconds otherwise it will not work with shutdown https://github.com/redis/go-redis/issues/2556
 		if err != nil {
 			if err == redis.Nil {
 				continue
@@ -94,12 +319,372 @@ func (a *Agent) Run(ctx context.Context) {
 			continue
 		}
 
-		if len(repos) != 2 || repos[0] != localredis.RedisRepoListKey {
+		if len(repos) != 2 || !slices.Contains(queues, repos[0]) {
 			logrus.Errorf("unexpected reply from BLpop: %s", strings.Join(repos, ","))
 			continue
 		}
+		sched.popped(repos[0])
+
+		t := &task{id: newRunID(), job: repos[1]}
+		if !a.admit(ctx, sched, t, repos[0]) {
+			continue
+		}
 
-		reposToProcess <- repos[1]
+		a.workers.run(func() {
+			a.process(ctx, t)
+		})
 	}
+	a.workers.wg.Wait()
 	wg.Wait()
 }
+
+func (a *Agent) process(ctx context.Context, t *task) {
+	defer t.release()
+	leaseCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go limit.KeepAlive(leaseCtx, t.leases, func(err error) { logrus.Error(err) })
+
+	repo := t.job
+	run := &history.Run{
+		ID:       t.id,
+		Job:      repo,
+		Repo:     repo,
+		Agent:    a.ID,
+		AgentURL: a.URL,
+		Started:  time.Now(),
+		Status:   history.StatusRunning,
+	}
+	job, err := renovate.ParseJob(repo)
+	if err == nil {
+		run.Repo = job.Repo
+		run.Version = job.RenovateVersion()
+	} else {
+		job = renovate.Job{Repo: repo}
+	}
+	override := a.override(ctx, job)
+	if !override.Empty() {
+		run.Overrides = &override
+	}
+
+	stream := logstore.NewStream(streamLines)
+	a.running.add(run, stream)
+	defer a.running.remove(run.ID)
+	defer stream.Close()
+
+	err = history.Start(ctx, a.RedisClient, run)
+	if err != nil {
+		logrus.Error(err)
+	}
+
+	outputs := []io.Writer{stream}
+	var output *logstore.TailBuffer
+	if a.LogStore != nil {
+		output = logstore.NewTailBuffer(a.LogMaxSize)
+		outputs = append(outputs, output)
+	}
+	opts := renovate.RunOptions{
+		Output: io.MultiWriter(outputs...),
+		Env:    override.Environ(),
+		Args:   override.Args,
+	}
+
+	var ws *workspace.Workspace
+	if a.Workspaces != nil {
+		var err error
+		ws, err = a.Workspaces.Create(run.ID)
+		if err != nil {
+			logrus.Error(err)
+		} else {
+			opts.Env = append(opts.Env, ws.Env()...)
+			defer a.removeWorkspace(ws)
+		}
+	}
+	if ws != nil && a.RepoCache != nil {
+		opts.Env = append(opts.Env, "RENOVATE_REPOSITORY_CACHE=enabled")
+		a.restoreRepoCache(ctx, job, ws)
+	}
+
+	var result *renovate.RunResult
+	err = a.injectSecrets(ctx, repo, &opts)
+	if err == nil {
+		logrus.Infof("running renovate on repo: %s run: %s", repo, run.ID)
+		result, err = a.Renovator.RunRenovate(repo, opts)
+	}
+	run.Finished = time.Now()
+	run.Result = result
+	run.Status = history.StatusOK
+	if err != nil {
+		run.Status = history.StatusError
+		if errors.Is(err, command.ErrOOMKilled) {
+			run.Status = history.StatusOOM
+		}
+		run.Error = err.Error()
+	}
+
+	if err == nil && ws != nil && a.RepoCache != nil {
+		if err := a.RepoCache.Save(ctx, job, repocache.Dir(ws.CacheDir)); err != nil {
+			logrus.Error(err)
+		}
+	}
+
+	a.recordBatch(ctx, repo, run.Status)
+	a.recordCanary(ctx, run)
+	a.saveRun(ctx, run, output)
+
+	if err != nil {
+		renovateRuns.WithLabelValues(run.Status, run.Repo, run.Version).Inc()
+		logrus.Errorf("error renovating repo: %s err: %s", repo, err)
+		return
+	}
+	renovateRuns.WithLabelValues("ok", run.Repo, run.Version).Inc()
+	pullRequests.WithLabelValues("created").Add(float64(result.PRsCreated))
+	pullRequests.WithLabelValues("updated").Add(float64(result.PRsUpdated))
+	fields := logrus.Fields{
+		"status":     result.Status,
+		"prsCreated": result.PRsCreated,
+		"prsUpdated": result.PRsUpdated,
+		"errors":     len(result.Errors),
+	}
+	if result.Branches != nil {
+		fields["branches"] = *result.Branches
+	}
+	logrus.WithFields(fields).Infof("finished renovating repo: %s in %s", repo, result.Duration)
+}
+
+// injectSecrets adds the credentials of the platform of the job, the secrets of the project of its repo and a token
+// minted by the GitHub App to the environment of renovate and redacts them from its output. The secrets are looked up by
+// the project qualified by the platform of the job and can only replace the credentials of platforms allowing it.
+func (a *Agent) injectSecrets(ctx context.Context, s string, opts *renovate.RunOptions) error {
+	job, err := renovate.ParseJob(s)
+	if err != nil {
+		return nil // reported by RunRenovate
+	}
+
+	var credentials map[string]string
+	secretsOverride := true
+	if job.Platform != "" && len(a.Platforms) > 0 {
+		p, ok := platform.Find(a.Platforms, job.Platform, job.Endpoint)
+		if !ok {
+			return fmt.Errorf("no credentials configured for %s %s", job.Platform, job.Endpoint)
+		}
+		credentials, err = p.Credentials()
+		if err != nil {
+			return err
+		}
+		secretsOverride = p.SecretsOverride
+	}
+	var projectSecrets map[string]string
+	if a.Secrets != nil && job.Project() != "" {
+		project := job.Scope(job.Project())
+		projectSecrets, err = a.Secrets.Secrets(ctx, project)
+		if err != nil {
+			return fmt.Errorf("error resolving secrets of project %s, err: %w", project, err)
+		}
+	}
+
+	found := map[string]string{}
+	if secretsOverride {
+		maps.Copy(found, credentials)
+		maps.Copy(found, projectSecrets)
+	} else {
+		maps.Copy(found, projectSecrets)
+		maps.Copy(found, credentials)
+	}
+	if a.GitHubApp != nil && a.onGitHubApp(job) {
+		token, err := a.GitHubApp.Token(ctx, job.Repo)
+		if err != nil {
+			return fmt.Errorf("error minting github app token for %s, err: %w", job.Repo, err)
+		}
+		found["RENOVATE_TOKEN"] = token
+	}
+	opts.Env = append(opts.Env, secrets.Environ(found)...)
+	opts.Redact = secrets.Values(found)
+	return nil
+}
+
+// onGitHubApp returns true if job runs on the github the app is installed on.
+func (a *Agent) onGitHubApp(job renovate.Job) bool {
+	p, endpoint := job.Platform, job.Endpoint
+	if p == "" {
+		p, endpoint = a.DefaultPlatform, a.DefaultEndpoint
+	}
+	return (p == "" || p == "github") && a.GitHubApp.Serves(endpoint)
+}
+
+// override returns the extra environment and arguments configured for the repo of job. Nothing is overridden if any of them is not allowed.
+func (a *Agent) override(ctx context.Context, job renovate.Job) overrides.Override {
+	if len(a.OverrideAllowlist) == 0 {
+		return overrides.Override{}
+	}
+	o, err := overrides.ForJob(ctx, a.RedisClient, job)
+	if err == nil {
+		err = a.OverrideAllowlist.Validate(o)
+	}
+	if err != nil {
+		logrus.Errorf("ignoring overrides of %s: %s", job.Target(), err)
+		return overrides.Override{}
+	}
+	return o
+}
+
+// restoreRepoCache restores the renovate repository cache of the target of job from the last successful run on any agent.
+func (a *Agent) restoreRepoCache(ctx context.Context, job renovate.Job, ws *workspace.Workspace) {
+	err := a.RepoCache.Restore(ctx, job, repocache.Dir(ws.CacheDir))
+	switch {
+	case errors.Is(err, blob.ErrNotFound):
+		repoCacheRestores.WithLabelValues("miss").Inc()
+	case err != nil:
+		repoCacheRestores.WithLabelValues("error").Inc()
+		logrus.Errorf("error restoring repository cache: %s", err)
+	default:
+		repoCacheRestores.WithLabelValues("hit").Inc()
+	}
+}
+
+// removeWorkspace records the disk usage of a run, removes its workspace and evicts the shared cache.
+func (a *Agent) removeWorkspace(ws *workspace.Workspace) {
+	if size, err := workspace.Size(ws.Dir); err == nil {
+		workspaceSize.Observe(float64(size))
+	}
+	if err := ws.Remove(); err != nil {
+		logrus.Error(err)
+	}
+
+	evicted, err := a.Workspaces.EvictCache()
+	if err != nil {
+		logrus.Error(err)
+	}
+	cacheEvicted.Add(float64(evicted))
+	if size, err := a.Workspaces.CacheSize(); err == nil {
+		cacheSize.Set(float64(size))
+	}
+}
+
+// heartbeat keeps the agent registered and its concurrency up to date until ctx is cancelled.
+func (a *Agent) heartbeat(ctx context.Context) {
+	interval := a.HeartbeatInterval
+	if interval == 0 {
+		interval = defaultHeartbeatInterval
+	}
+	hostname, _ := os.Hostname()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		a.adjustConcurrency(ctx)
+		capacity, _ := a.workers.sizes()
+		err := registry.Register(ctx, a.RedisClient, registry.Agent{
+			ID:        a.ID,
+			Hostname:  hostname,
+			Version:   a.Version,
+			URL:       a.URL,
+			Capacity:  capacity,
+			Labels:    a.Labels,
+			Running:   a.running.repos(),
+			Heartbeat: time.Now(),
+		}, 3*interval)
+		if err != nil {
+			logrus.Error(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err = registry.Unregister(shutdownCtx, a.RedisClient, a.ID)
+			if err != nil {
+				logrus.Error(err)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// saveRun stores the run in the history and its output in the log store.
+func (a *Agent) saveRun(ctx context.Context, run *history.Run, output *logstore.TailBuffer) {
+	if output != nil {
+		err := a.LogStore.Save(ctx, run.Repo, run.ID, output.Bytes())
+		if err != nil {
+			logrus.Error(err)
+		}
+	}
+
+	err := history.Save(ctx, a.RedisClient, run)
+	if err != nil {
+		logrus.Error(err)
+	}
+}
+
+// runningJobs keeps track of the jobs currently running on the agent.
+type runningJobs struct {
+	mu   sync.Mutex
+	jobs map[string]*runningJob
+}
+
+type runningJob struct {
+	run    *history.Run
+	stream *logstore.Stream
+}
+
+func (r *runningJobs) add(run *history.Run, stream *logstore.Stream) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.jobs == nil {
+		r.jobs = make(map[string]*runningJob)
+	}
+	r.jobs[run.ID] = &runningJob{run: run, stream: stream}
+}
+
+func (r *runningJobs) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.jobs, id)
+}
+
+func (r *runningJobs) repos() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	repos := make([]string, 0, len(r.jobs))
+	for _, job := range r.jobs {
+		repos = append(repos, job.run.Repo)
+	}
+	sort.Strings(repos)
+	return repos
+}
+
+func (r *runningJobs) get(id string) *runningJob {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.jobs[id]
+}
+
+func newRunID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(b)
+}
+
+// recordBatch counts the result of the job in its batch if it was queued as part of one.
+func (a *Agent) recordBatch(ctx context.Context, repo string, status string) {
+	job, err := renovate.ParseJob(repo)
+	if err != nil || job.Batch == "" {
+		return
+	}
+
+	err = a.RedisClient.HIncrBy(ctx, localredis.BatchKey(job.Batch), status, 1).Err()
+	if err != nil {
+		logrus.Errorf("error updating batch %s: %s", job.Batch, err)
+	}
+}
+
+// recordCanary counts the finished run in the canary group of the agent.
+func (a *Agent) recordCanary(ctx context.Context, run *history.Run) {
+	if a.CanaryGroup == "" {
+		return
+	}
+	err := canary.Record(ctx, a.RedisClient, a.CanaryGroup, run.Status, run.Finished)
+	if err != nil {
+		logrus.Error(err)
+	}
+}
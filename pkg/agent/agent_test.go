@@ -2,15 +2,35 @@ package agent
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/fortnoxab/renovator/mocks"
+	"github.com/fortnoxab/renovator/pkg/blob"
+	"github.com/fortnoxab/renovator/pkg/command"
+	"github.com/fortnoxab/renovator/pkg/githubapp"
+	"github.com/fortnoxab/renovator/pkg/history"
+	"github.com/fortnoxab/renovator/pkg/overrides"
+	"github.com/fortnoxab/renovator/pkg/platform"
 	localredis "github.com/fortnoxab/renovator/pkg/redis"
 	"github.com/fortnoxab/renovator/pkg/renovate"
+	"github.com/fortnoxab/renovator/pkg/repocache"
+	"github.com/fortnoxab/renovator/pkg/secrets"
+	"github.com/fortnoxab/renovator/pkg/workspace"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -21,6 +41,7 @@ func TestRun(t *testing.T) {
 	commanderMock := mocks.NewMockCommander(t)
 	redisMock := mocks.NewMockCmdable(t)
 	a := &Agent{
+		ID:              "agent1",
 		Renovator:       renovate.NewRunner(commanderMock),
 		RedisClient:     redisMock,
 		MaxProcessCount: 2,
@@ -30,36 +51,89 @@ func TestRun(t *testing.T) {
 		list: []string{"project1/repo1", "project1/repo2", "project2/repo1"},
 	}
 
+	redisMock.On("SMembers", mock.Anything, "renovator-paused").
+		Return(redis.NewStringSliceResult(nil, nil))
+	redisMock.On("SMembers", mock.Anything, "renovator-queues").
+		Return(redis.NewStringSliceResult(nil, nil))
 	redisMockCall := redisMock.On("BLPop", mock.Anything, time.Duration(time.Second*5), "renovator-joblist")
 	redisMockCall.RunFn = func(a mock.Arguments) {
 		redisMockCall.ReturnArguments = mock.Arguments{redisMockList.LPop()}
 	}
 
-	commanderMock.On("RunWithEnv", []string{}, "renovate", "project1/repo1").
+	commanderMock.On("RunWithOutput", mock.Anything, []string{"LOG_FORMAT=json"}, "renovate", "project1/repo1").
 		Run(func(args mock.Arguments) {
 			time.Sleep(200 * time.Millisecond)
 		}).
 		Return(nil).
 		Once()
-	commanderMock.On("RunWithEnv", []string{}, "renovate", "project1/repo2").
+	commanderMock.On("RunWithOutput", mock.Anything, []string{"LOG_FORMAT=json"}, "renovate", "project1/repo2").
 		Run(func(args mock.Arguments) {
 			time.Sleep(200 * time.Millisecond)
 		}).
 		Return(nil).
 		Once()
-	commanderMock.On("RunWithEnv", []string{}, "renovate", "project2/repo1").
+	commanderMock.On("RunWithOutput", mock.Anything, []string{"LOG_FORMAT=json"}, "renovate", "project2/repo1").
 		Run(func(args mock.Arguments) {
 			time.Sleep(200 * time.Millisecond)
 		}).
 		Return(nil).
 		Once()
 
+	for _, repo := range redisMockList.list {
+		savedRun(redisMock, repo)
+		repoLock(redisMock, repo)
+	}
+	heartbeat(redisMock)
+
 	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
 	defer cancel()
 
 	a.Run(ctx)
 }
 
+func heartbeat(redisMock *mocks.MockCmdable) {
+	redisMock.On("Get", mock.Anything, "renovator-agent-concurrency:agent1").
+		Return(redis.NewStringResult("", redis.Nil)).
+		Once()
+	redisMock.On("Set", mock.Anything, "renovator-agent:agent1", mock.Anything, 3*defaultHeartbeatInterval).
+		Return(redis.NewStatusResult("OK", nil)).
+		Once()
+	redisMock.On("SAdd", mock.Anything, "renovator-agents", "agent1").
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+	redisMock.On("Del", mock.Anything, "renovator-agent:agent1").
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+	redisMock.On("SRem", mock.Anything, "renovator-agents", "agent1").
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+}
+
+func repoLock(redisMock *mocks.MockCmdable, repo string) {
+	redisMock.On("EvalSha", mock.Anything, mock.Anything, []string{"renovator-lock:" + repo}, mock.Anything, 1, mock.Anything, mock.Anything, int64(60000)).
+		Return(redis.NewCmdResult(int64(1), nil)).
+		Once()
+	redisMock.On("ZRem", mock.Anything, "renovator-lock:"+repo, mock.Anything).
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+}
+
+// savedRun expects a run to be saved in the history of target, see history.Run.Target.
+func savedRun(redisMock *mocks.MockCmdable, target string) {
+	redisMock.On("Set", mock.Anything, mock.MatchedBy(func(key string) bool { return strings.HasPrefix(key, "renovator-run:") }), mock.Anything, history.TTL).
+		Return(redis.NewStatusResult("OK", nil)).
+		Twice()
+	redisMock.On("LPush", mock.Anything, "renovator-history:"+target, mock.AnythingOfType("string")).
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+	redisMock.On("LTrim", mock.Anything, "renovator-history:"+target, int64(0), int64(history.Length-1)).
+		Return(redis.NewStatusResult("OK", nil)).
+		Once()
+	redisMock.On("Expire", mock.Anything, "renovator-history:"+target, history.TTL).
+		Return(redis.NewBoolResult(true, nil)).
+		Once()
+}
+
 type redisMockList struct {
 	lock sync.RWMutex
 	list []string
@@ -77,3 +151,296 @@ func (t *redisMockList) LPop() *redis.StringSliceCmd {
 	t.list = t.list[1:]
 	return redis.NewStringSliceResult([]string{localredis.RedisRepoListKey, first}, nil)
 }
+
+func TestProcessOOMKilled(t *testing.T) {
+	commanderMock := mocks.NewMockCommander(t)
+	redisMock := mocks.NewMockCmdable(t)
+	a := &Agent{
+		ID:          "agent1",
+		Renovator:   renovate.NewRunner(commanderMock),
+		RedisClient: redisMock,
+	}
+
+	commanderMock.On("RunWithOutput", mock.Anything, []string{"LOG_FORMAT=json"}, "renovate", "project1/repo1").
+		Return(fmt.Errorf("%w: signal: killed", command.ErrOOMKilled)).
+		Once()
+	savedRun(redisMock, "project1/repo1")
+	redisMock.On("HIncrBy", mock.Anything, "renovator-batch:batch1", "oom", int64(1)).
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+
+	// the metric is labelled with the repo and not the job so batches don't add series
+	counter := renovateRuns.WithLabelValues("oom", "project1/repo1", "default")
+	before := &dto.Metric{}
+	assert.NoError(t, counter.Write(before))
+
+	a.process(context.Background(), &task{id: "run1", job: "project1/repo1?batch=batch1"})
+	after := &dto.Metric{}
+	assert.NoError(t, counter.Write(after))
+	assert.Equal(t, before.GetCounter().GetValue()+1, after.GetCounter().GetValue())
+}
+
+func TestProcessOverrides(t *testing.T) {
+	commanderMock := mocks.NewMockCommander(t)
+	redisMock := mocks.NewMockCmdable(t)
+	a := &Agent{
+		ID:                "agent1",
+		Renovator:         renovate.NewRunner(commanderMock),
+		RedisClient:       redisMock,
+		OverrideAllowlist: overrides.Allowlist{"RENOVATE_PR_*"},
+	}
+
+	redisMock.On("HMGet", mock.Anything, "renovator-overrides", "project1", "project1/repo1").
+		Return(redis.NewSliceResult([]interface{}{`{"env":{"RENOVATE_PR_HOURLY_LIMIT":"2"}}`, `{"args":["--pr-concurrent-limit=1"]}`}, nil)).
+		Once()
+	commanderMock.On("RunWithOutput", mock.Anything, []string{"LOG_FORMAT=json", "RENOVATE_PR_HOURLY_LIMIT=2"}, "renovate", "--pr-concurrent-limit=1", "project1/repo1").
+		Return(nil).
+		Once()
+	savedRun(redisMock, "project1/repo1")
+
+	a.process(context.Background(), &task{id: "run1", job: "project1/repo1"})
+
+	// overrides are ignored if any of them is not allowed
+	redisMock.On("HMGet", mock.Anything, "renovator-overrides", "project1", "project1/repo1").
+		Return(redis.NewSliceResult([]interface{}{`{"env":{"RENOVATE_TOKEN":"secret"}}`, nil}, nil)).
+		Once()
+	commanderMock.On("RunWithOutput", mock.Anything, []string{"LOG_FORMAT=json"}, "renovate", "project1/repo1").
+		Return(nil).
+		Once()
+	savedRun(redisMock, "project1/repo1")
+
+	a.process(context.Background(), &task{id: "run2", job: "project1/repo1"})
+}
+
+func TestProcessSecrets(t *testing.T) {
+	commanderMock := mocks.NewMockCommander(t)
+	redisMock := mocks.NewMockCmdable(t)
+	dir := t.TempDir()
+	a := &Agent{
+		ID:          "agent1",
+		Renovator:   renovate.NewRunner(commanderMock),
+		RedisClient: redisMock,
+		Secrets:     &secrets.Files{Dir: dir},
+	}
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "project1"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "project1", "NPM_TOKEN"), []byte("npm-secret"), 0o600))
+
+	commanderMock.On("RunWithOutput", mock.Anything, []string{"LOG_FORMAT=json", "NPM_TOKEN=npm-secret"}, "renovate", "project1/repo1").
+		Return(nil).
+		Once()
+	savedRun(redisMock, "project1/repo1")
+	a.process(context.Background(), &task{id: "run1", job: "project1/repo1"})
+
+	// other projects don't get the secrets
+	commanderMock.On("RunWithOutput", mock.Anything, []string{"LOG_FORMAT=json"}, "renovate", "project2/repo1").
+		Return(nil).
+		Once()
+	savedRun(redisMock, "project2/repo1")
+	a.process(context.Background(), &task{id: "run2", job: "project2/repo1"})
+
+	// renovate is not started without its secrets
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "project1", "not-valid!"), []byte("x"), 0o600))
+	savedRun(redisMock, "project1/repo1")
+	a.process(context.Background(), &task{id: "run3", job: "project1/repo1"})
+}
+
+func TestProcessGitHubApp(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /orgs/org1/installation", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"id":11}`))
+	})
+	mux.HandleFunc("POST /app/installations/11/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		_, _ = fmt.Fprintf(w, `{"token":"ghs_token","expires_at":"%s"}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	commanderMock := mocks.NewMockCommander(t)
+	redisMock := mocks.NewMockCmdable(t)
+	a := &Agent{
+		ID:          "agent1",
+		Renovator:   renovate.NewRunner(commanderMock),
+		RedisClient: redisMock,
+		GitHubApp:   &githubapp.App{ID: 1, PrivateKey: key, Endpoint: ts.URL},
+	}
+
+	commanderMock.On("RunWithOutput", mock.Anything, []string{"LOG_FORMAT=json", "RENOVATE_TOKEN=ghs_token"}, "renovate", "org1/repo1").
+		Return(nil).
+		Once()
+	savedRun(redisMock, "org1/repo1")
+	a.process(context.Background(), &task{id: "run1", job: "org1/repo1"})
+
+	// renovate is not started without a token
+	savedRun(redisMock, "org2/repo1")
+	a.process(context.Background(), &task{id: "run2", job: "org2/repo1"})
+
+	// no token is minted for jobs on other platforms
+	a.DefaultPlatform = "bitbucket-server"
+	a.DefaultEndpoint = "https://bitbucket.example.com"
+	commanderMock.On("RunWithOutput", mock.Anything, []string{"LOG_FORMAT=json"}, "renovate", "PROJECT/repo1").
+		Return(nil).
+		Once()
+	savedRun(redisMock, "PROJECT/repo1")
+	a.process(context.Background(), &task{id: "run3", job: "PROJECT/repo1"})
+
+	// jobs on github get a token from agents with another default platform
+	commanderMock.On("RunWithOutput", mock.Anything, []string{
+		"LOG_FORMAT=json",
+		"RENOVATE_PLATFORM=github",
+		"RENOVATE_ENDPOINT=" + ts.URL,
+		"RENOVATE_TOKEN=ghs_token",
+	}, "renovate", "org1/repo2").
+		Return(nil).
+		Once()
+	job := renovate.Job{Repo: "org1/repo2", Platform: "github", Endpoint: ts.URL}.String()
+	savedRun(redisMock, job)
+	a.process(context.Background(), &task{id: "run4", job: job})
+}
+
+func TestProcessPlatforms(t *testing.T) {
+	commanderMock := mocks.NewMockCommander(t)
+	redisMock := mocks.NewMockCmdable(t)
+	a := &Agent{
+		ID:          "agent1",
+		Renovator:   renovate.NewRunner(commanderMock),
+		RedisClient: redisMock,
+		Platforms: []platform.Platform{
+			{Platform: "github", Endpoint: "https://api.github.com", Token: "github-token"},
+			{Platform: "gitlab", Endpoint: "https://gitlab.example.com/api/v4", Token: "gitlab-token"},
+		},
+	}
+
+	commanderMock.On("RunWithOutput", mock.Anything, []string{
+		"LOG_FORMAT=json",
+		"RENOVATE_PLATFORM=gitlab",
+		"RENOVATE_ENDPOINT=https://gitlab.example.com/api/v4",
+		"RENOVATE_TOKEN=gitlab-token",
+	}, "renovate", "group1/repo1").
+		Return(nil).
+		Once()
+	savedRun(redisMock, "group1/repo1?endpoint=https%3A%2F%2Fgitlab.example.com%2Fapi%2Fv4&platform=gitlab")
+	a.process(context.Background(), &task{id: "run1", job: "group1/repo1?endpoint=https%3A%2F%2Fgitlab.example.com%2Fapi%2Fv4&platform=gitlab"})
+
+	// renovate is not started on platforms without credentials
+	savedRun(redisMock, "project1/repo1?endpoint=https%3A%2F%2Fbitbucket.example.com&platform=bitbucket-server")
+	a.process(context.Background(), &task{id: "run2", job: "project1/repo1?endpoint=https%3A%2F%2Fbitbucket.example.com&platform=bitbucket-server"})
+}
+
+func TestProcessPlatformSecrets(t *testing.T) {
+	commanderMock := mocks.NewMockCommander(t)
+	redisMock := mocks.NewMockCmdable(t)
+	dir := t.TempDir()
+	a := &Agent{
+		ID:          "agent1",
+		Renovator:   renovate.NewRunner(commanderMock),
+		RedisClient: redisMock,
+		Secrets:     &secrets.Files{Dir: dir},
+		Platforms: []platform.Platform{
+			{Platform: "github", Endpoint: "https://api.github.com", Token: "github-token"},
+		},
+	}
+	// the project acme on the default platform and the organization acme on github have their own secrets
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "acme"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "acme", "RENOVATE_TOKEN"), []byte("bitbucket-token"), 0o600))
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "github", "api.github.com", "acme"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "github", "api.github.com", "acme", "NPM_TOKEN"), []byte("npm-token"), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "github", "api.github.com", "acme", "RENOVATE_TOKEN"), []byte("project-token"), 0o600))
+	job := renovate.Job{Repo: "acme/repo1", Platform: "github", Endpoint: "https://api.github.com"}.String()
+
+	// the credentials of the platform are not replaced by the secrets of the project
+	commanderMock.On("RunWithOutput", mock.Anything, []string{
+		"LOG_FORMAT=json",
+		"RENOVATE_PLATFORM=github",
+		"RENOVATE_ENDPOINT=https://api.github.com",
+		"NPM_TOKEN=npm-token",
+		"RENOVATE_TOKEN=github-token",
+	}, "renovate", "acme/repo1").
+		Return(nil).
+		Once()
+	savedRun(redisMock, job)
+	a.process(context.Background(), &task{id: "run1", job: job})
+
+	a.Platforms[0].SecretsOverride = true
+	commanderMock.On("RunWithOutput", mock.Anything, []string{
+		"LOG_FORMAT=json",
+		"RENOVATE_PLATFORM=github",
+		"RENOVATE_ENDPOINT=https://api.github.com",
+		"NPM_TOKEN=npm-token",
+		"RENOVATE_TOKEN=project-token",
+	}, "renovate", "acme/repo1").
+		Return(nil).
+		Once()
+	savedRun(redisMock, job)
+	a.process(context.Background(), &task{id: "run2", job: job})
+}
+
+func TestProcessWorkspace(t *testing.T) {
+	commanderMock := mocks.NewMockCommander(t)
+	redisMock := mocks.NewMockCmdable(t)
+	a := &Agent{
+		ID:          "agent1",
+		Renovator:   renovate.NewRunner(commanderMock),
+		RedisClient: redisMock,
+		Workspaces:  &workspace.Manager{Dir: t.TempDir()},
+	}
+	runDir := filepath.Join(a.Workspaces.Dir, "runs", "run1")
+
+	commanderMock.On("RunWithOutput", mock.Anything, []string{
+		"LOG_FORMAT=json",
+		"RENOVATE_BASE_DIR=" + runDir,
+		"RENOVATE_CACHE_DIR=" + filepath.Join(a.Workspaces.Dir, "cache"),
+		"TMPDIR=" + filepath.Join(runDir, "tmp"),
+	}, "renovate", "project1/repo1").
+		Run(func(args mock.Arguments) {
+			assert.DirExists(t, runDir)
+		}).
+		Return(nil).
+		Once()
+	savedRun(redisMock, "project1/repo1")
+
+	a.process(context.Background(), &task{id: "run1", job: "project1/repo1"})
+	assert.NoDirExists(t, runDir)
+}
+
+func TestProcessRepoCache(t *testing.T) {
+	commanderMock := mocks.NewMockCommander(t)
+	redisMock := mocks.NewMockCmdable(t)
+	bucket := &blob.Dir{Path: t.TempDir()}
+	a := &Agent{
+		ID:          "agent1",
+		Renovator:   renovate.NewRunner(commanderMock),
+		RedisClient: redisMock,
+		Workspaces:  &workspace.Manager{Dir: t.TempDir()},
+		RepoCache:   &repocache.Store{Bucket: bucket, DefaultPlatform: "bitbucket-server"},
+	}
+	cacheFile := filepath.Join(repocache.Dir(filepath.Join(a.Workspaces.Dir, "cache")), "bitbucket-server", "project1", "repo1.json")
+
+	commanderMock.On("RunWithOutput", mock.Anything, mock.MatchedBy(func(env []string) bool {
+		return slices.Contains(env, "RENOVATE_REPOSITORY_CACHE=enabled")
+	}), "renovate", "project1/repo1").
+		Run(func(args mock.Arguments) {
+			assert.NoError(t, os.MkdirAll(filepath.Dir(cacheFile), 0o755))
+			assert.NoError(t, os.WriteFile(cacheFile, []byte(`{"revision":13}`), 0o600))
+		}).
+		Return(nil).
+		Once()
+	savedRun(redisMock, "project1/repo1")
+
+	a.process(context.Background(), &task{id: "run1", job: "project1/repo1"})
+	assert.FileExists(t, filepath.Join(bucket.Path, "project1", "repo1.tar.gz"))
+
+	// the cache is restored on an agent that has not run the repo before
+	assert.NoError(t, os.RemoveAll(a.Workspaces.Dir))
+	commanderMock.On("RunWithOutput", mock.Anything, mock.Anything, "renovate", "project1/repo1").
+		Run(func(args mock.Arguments) {
+			assert.FileExists(t, cacheFile)
+		}).
+		Return(nil).
+		Once()
+	savedRun(redisMock, "project1/repo1")
+
+	a.process(context.Background(), &task{id: "run2", job: "project1/repo1"})
+}
@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (a *Agent) routes(router *gin.Engine) {
+	router.GET("/api/runs/:id/stream", a.streamRun)
+	router.GET("/api/concurrency", a.getConcurrency)
+}
+
+func (a *Agent) adminRoutes(router gin.IRoutes) {
+	router.PUT("/api/concurrency", a.putConcurrency)
+}
+
+type concurrencyStatus struct {
+	Concurrency int `json:"concurrency"`
+	Limit       int `json:"limit"`
+	Running     int `json:"running"`
+}
+
+func (a *Agent) getConcurrency(c *gin.Context) {
+	size, running := a.workers.sizes()
+	limit, _ := a.concurrency.get()
+	c.JSON(http.StatusOK, concurrencyStatus{Concurrency: size, Limit: limit, Running: running})
+}
+
+// putConcurrency changes the number of simultaneous renovate processes. It is stored in redis so it is kept after restarts.
+func (a *Agent) putConcurrency(c *gin.Context) {
+	var body struct {
+		Concurrency *int `json:"concurrency"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.Concurrency == nil || *body.Concurrency < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "expected body {\"concurrency\": <number>}"})
+		return
+	}
+
+	err := a.RedisClient.Set(c.Request.Context(), concurrencyKeyPrefix+a.ID, *body.Concurrency, 0).Err()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	a.SetConcurrency(*body.Concurrency)
+	a.getConcurrency(c)
+}
+
+// streamRun streams the output of a running job as server-sent events.
+func (a *Agent) streamRun(c *gin.Context) {
+	job := a.running.get(c.Param("id"))
+	if job == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "run is not running on this agent"})
+		return
+	}
+
+	backlog, lines, cancel := job.stream.Subscribe()
+	defer cancel()
+
+	// the stream is open for as long as the job runs
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	c.Header("Cache-Control", "no-cache")
+	for _, line := range backlog {
+		c.SSEvent("log", line)
+	}
+	c.Writer.Flush()
+
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				c.SSEvent("end", job.run.ID)
+				c.Writer.Flush()
+				return
+			}
+			c.SSEvent("log", line)
+			c.Writer.Flush()
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
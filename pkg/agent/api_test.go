@@ -0,0 +1,78 @@
+package agent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fortnoxab/renovator/mocks"
+	"github.com/fortnoxab/renovator/pkg/history"
+	"github.com/fortnoxab/renovator/pkg/logstore"
+	"github.com/fortnoxab/renovator/pkg/webserver"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestStreamRun(t *testing.T) {
+	a := &Agent{}
+	router := (&webserver.Webserver{Routes: a.routes}).Init()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/runs/run1/stream", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	stream := logstore.NewStream(10)
+	a.running.add(&history.Run{ID: "run1"}, stream)
+	_, _ = stream.Write([]byte("line1\n"))
+	go func() {
+		_, _ = stream.Write([]byte("line2\n"))
+		stream.Close()
+	}()
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/runs/run1/stream", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	assert.Equal(t, "event:log\ndata:line1\n\nevent:log\ndata:line2\n\nevent:end\ndata:run1\n\n", w.Body.String())
+}
+
+func TestPutConcurrency(t *testing.T) {
+	redisMock := mocks.NewMockCmdable(t)
+	a := &Agent{ID: "agent1", RedisClient: redisMock}
+	router := (&webserver.Webserver{Routes: a.routes, AdminRoutes: a.adminRoutes, AdminToken: "secret"}).Init()
+	put := func(body string, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/api/concurrency", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/api/concurrency", strings.NewReader(`{"concurrency": 3}`)))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, http.StatusUnauthorized, put(`{"concurrency": 3}`, "wrong").Code)
+
+	assert.Equal(t, http.StatusBadRequest, put(`{"concurrency": -1}`, "secret").Code)
+
+	redisMock.On("Set", mock.Anything, "renovator-agent-concurrency:agent1", 3, time.Duration(0)).
+		Return(redis.NewStatusResult("OK", nil)).
+		Once()
+	w = put(`{"concurrency": 3}`, "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"concurrency": 3, "limit": 3, "running": 0}`, w.Body.String())
+}
+
+func TestPutConcurrencyWithoutAdminToken(t *testing.T) {
+	a := &Agent{ID: "agent1"}
+	router := (&webserver.Webserver{Routes: a.routes, AdminRoutes: a.adminRoutes}).Init()
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, "/api/concurrency", strings.NewReader(`{"concurrency": 3}`))
+	req.Header.Set("Authorization", "Bearer ")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
@@ -0,0 +1,223 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+// concurrencyKeyPrefix is the prefix of the redis keys overriding the concurrency of an agent.
+const concurrencyKeyPrefix = "renovator-agent-concurrency:"
+
+var agentConcurrency = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "renovator_agent_concurrency",
+	Help: "Number of renovate processes the agent runs simultaneously",
+})
+
+func init() {
+	prometheus.MustRegister(agentConcurrency)
+}
+
+// pool runs jobs concurrently up to a size that can be changed while running. Shrinking it lets
+// running jobs finish and no new ones start until there are fewer running than the new size.
+type pool struct {
+	mu      sync.Mutex
+	size    int
+	running int
+	changed chan struct{}
+	wg      sync.WaitGroup
+}
+
+func (p *pool) resize(size int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.size = size
+	p.notify()
+}
+
+func (p *pool) sizes() (size int, running int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.size, p.running
+}
+
+// wait blocks until a job can be started. It returns false if ctx is cancelled.
+func (p *pool) wait(ctx context.Context) bool {
+	for {
+		p.mu.Lock()
+		if p.running < p.size {
+			p.mu.Unlock()
+			return true
+		}
+		if p.changed == nil {
+			p.changed = make(chan struct{})
+		}
+		changed := p.changed
+		p.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-changed:
+		}
+	}
+}
+
+// run starts fn in a new goroutine.
+func (p *pool) run(fn func()) {
+	p.mu.Lock()
+	p.running++
+	p.mu.Unlock()
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer func() {
+			p.mu.Lock()
+			p.running--
+			p.notify()
+			p.mu.Unlock()
+		}()
+		fn()
+	}()
+}
+
+// notify wakes up waiters, p.mu must be held.
+func (p *pool) notify() {
+	if p.changed != nil {
+		close(p.changed)
+		p.changed = nil
+	}
+}
+
+// concurrency is the maximum number of simultaneous jobs set through the api or redis, and the
+// number the agent has tuned itself down to based on host load.
+type concurrency struct {
+	mu    sync.Mutex
+	limit int
+	tuned int
+}
+
+func (c *concurrency) get() (limit int, tuned int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.limit, c.tuned
+}
+
+// SetConcurrency changes the number of simultaneous renovate processes.
+func (a *Agent) SetConcurrency(n int) {
+	a.concurrency.mu.Lock()
+	a.concurrency.limit = n
+	if a.concurrency.tuned == 0 || a.concurrency.tuned > n {
+		a.concurrency.tuned = n
+	}
+	a.concurrency.mu.Unlock()
+	a.applyConcurrency()
+}
+
+func (a *Agent) applyConcurrency() {
+	limit, tuned := a.concurrency.get()
+	size := limit
+	if a.AutoTune {
+		size = min(limit, tuned)
+	}
+
+	if current, _ := a.workers.sizes(); current != size {
+		logrus.Infof("changing concurrency from %d to %d", current, size)
+	}
+	a.workers.resize(size)
+	agentConcurrency.Set(float64(size))
+}
+
+// adjustConcurrency applies the concurrency stored in redis and tunes it to the host load.
+func (a *Agent) adjustConcurrency(ctx context.Context) {
+	value, err := a.RedisClient.Get(ctx, concurrencyKeyPrefix+a.ID).Result()
+	switch {
+	case err == redis.Nil:
+	case err != nil:
+		logrus.Errorf("error getting concurrency from redis: %s", err)
+	default:
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			logrus.Errorf("invalid concurrency in %s: '%s'", concurrencyKeyPrefix+a.ID, value)
+			break
+		}
+		if limit, _ := a.concurrency.get(); limit != n {
+			a.SetConcurrency(n)
+		}
+	}
+
+	if !a.AutoTune {
+		return
+	}
+	cpu, mem, err := hostLoad()
+	if err != nil {
+		logrus.Errorf("error reading host load: %s", err)
+		return
+	}
+	a.concurrency.mu.Lock()
+	a.concurrency.tuned = tune(a.concurrency.tuned, a.concurrency.limit, cpu, mem)
+	a.concurrency.mu.Unlock()
+	a.applyConcurrency()
+}
+
+// tune steps the concurrency down when the host is overloaded and up when it has capacity left.
+func tune(current int, limit int, cpu float64, mem float64) int {
+	switch {
+	case cpu > 0.9 || mem > 0.9:
+		current--
+	case cpu < 0.7 && mem < 0.75:
+		current++
+	}
+	return max(min(current, limit), min(1, limit))
+}
+
+// hostLoad returns the 1 minute load average per cpu and the ratio of memory in use.
+func hostLoad() (cpu float64, mem float64, err error) {
+	b, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, 0, err
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return 0, 0, fmt.Errorf("unexpected /proc/loadavg: %s", b)
+	}
+	load, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	values := make(map[string]float64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			continue
+		}
+		values[name], _ = strconv.ParseFloat(fields[0], 64)
+	}
+	if values["MemTotal"] == 0 {
+		return 0, 0, fmt.Errorf("no MemTotal in /proc/meminfo")
+	}
+
+	return load / float64(runtime.NumCPU()), 1 - values["MemAvailable"]/values["MemTotal"], scanner.Err()
+}
@@ -0,0 +1,65 @@
+package agent
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoolResize(t *testing.T) {
+	p := &pool{}
+	p.resize(2)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	release := make(chan struct{})
+	var done atomic.Int32
+	for range 2 {
+		assert.True(t, p.wait(ctx))
+		p.run(func() {
+			<-release
+			done.Add(1)
+		})
+	}
+
+	p.resize(1)
+	waitCtx, waitCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	assert.False(t, p.wait(waitCtx), "should not start jobs while more than the new size are running")
+	waitCancel()
+
+	close(release)
+	assert.True(t, p.wait(ctx))
+	p.wg.Wait()
+	assert.Equal(t, int32(2), done.Load())
+	size, running := p.sizes()
+	assert.Equal(t, 1, size)
+	assert.Equal(t, 0, running)
+}
+
+func TestTune(t *testing.T) {
+	assert.Equal(t, 3, tune(4, 4, 0.95, 0.5))
+	assert.Equal(t, 3, tune(4, 4, 0.5, 0.95))
+	assert.Equal(t, 4, tune(3, 4, 0.5, 0.5))
+	assert.Equal(t, 4, tune(4, 4, 0.5, 0.5))
+	assert.Equal(t, 3, tune(3, 4, 0.8, 0.5))
+	assert.Equal(t, 1, tune(1, 4, 0.95, 0.95))
+}
+
+func TestSetConcurrency(t *testing.T) {
+	a := &Agent{AutoTune: true}
+	a.SetConcurrency(4)
+	size, _ := a.workers.sizes()
+	assert.Equal(t, 4, size)
+
+	a.concurrency.tuned = 2
+	a.applyConcurrency()
+	size, _ = a.workers.sizes()
+	assert.Equal(t, 2, size)
+
+	a.SetConcurrency(1)
+	size, _ = a.workers.sizes()
+	assert.Equal(t, 1, size)
+}
@@ -0,0 +1,195 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fortnoxab/renovator/pkg/limit"
+	localredis "github.com/fortnoxab/renovator/pkg/redis"
+	"github.com/fortnoxab/renovator/pkg/renovate"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// leaseTTL is how long a lease of a limit is kept after an agent stops refreshing it.
+const leaseTTL = time.Minute
+
+// pausedDelay is how long the agent waits before checking again when all projects are paused.
+const pausedDelay = 5 * time.Second
+
+// deferDelay is how long the queues of a project are skipped after a job from it was deferred.
+const deferDelay = time.Second
+
+// runningKeyPrefix is the prefix of the semaphores counting running jobs.
+const runningKeyPrefix = "renovator-running:"
+
+// repoLockKeyPrefix is the prefix of the locks preventing concurrent runs on the same repo, keyed by renovate.Job.Target.
+const repoLockKeyPrefix = "renovator-lock:"
+
+// startsKey is the token bucket limiting the rate of job starts.
+const startsKey = "renovator-starts"
+
+var deferredJobs = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "renovator_deferred_jobs",
+	Help: "Number of jobs taken from the queue that were pushed back because a limit was reached",
+}, []string{"reason"})
+
+func init() {
+	prometheus.MustRegister(deferredJobs)
+}
+
+// task is a job taken from a queue and the leases held while running it.
+type task struct {
+	id     string
+	job    string
+	leases []*limit.Lease
+}
+
+func (t *task) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, l := range t.leases {
+		if err := l.Release(ctx); err != nil {
+			logrus.Error(err)
+		}
+	}
+}
+
+// acquireGlobal takes a slot of the cluster-wide limit of running jobs for a job taken from the queue.
+func (a *Agent) acquireGlobal(ctx context.Context, t *task) bool {
+	if a.ClusterMaxRunning <= 0 {
+		return true
+	}
+
+	sem := limit.Semaphore{Key: runningKeyPrefix + "all", Limit: a.ClusterMaxRunning, TTL: leaseTTL}
+	lease, err := sem.Acquire(ctx, a.RedisClient, t.id)
+	if err != nil {
+		logrus.Error(err)
+	}
+	if lease == nil {
+		return false
+	}
+	t.leases = append(t.leases, lease)
+	return true
+}
+
+// admit acquires the cluster limit, checks that the project is not paused, acquires the project limit and the repo lock
+// and takes a start from the rate limit for a job taken from queue.
+// If the job can't start yet it is pushed back to the queue, the leases of t are released and false is returned.
+func (a *Agent) admit(ctx context.Context, sched *scheduler, t *task, queue string) bool {
+	if !a.acquireGlobal(ctx, t) {
+		logrus.Debugf("cluster has %d running jobs, deferring %s", a.ClusterMaxRunning, t.job)
+		deferredJobs.WithLabelValues("cluster_limit").Inc()
+		a.pushBack(ctx, t, queue)
+		sleep(ctx, deferDelay)
+		return false
+	}
+
+	job, err := renovate.ParseJob(t.job)
+	if err != nil {
+		return true
+	}
+
+	project := job.Project()
+	if project != "" && slices.Contains(sched.paused, project) {
+		logrus.Debugf("project %s is paused, deferring %s", project, t.job)
+		a.deferJob(ctx, sched, t, queue, "paused")
+		return false
+	}
+
+	max, ok := a.ProjectLimits[project]
+	if !ok {
+		max = a.ProjectLimits["*"]
+	}
+	if project != "" && max > 0 {
+		sem := limit.Semaphore{Key: runningKeyPrefix + "project=" + project, Limit: max, TTL: leaseTTL}
+		lease, err := sem.Acquire(ctx, a.RedisClient, t.id)
+		if err != nil {
+			logrus.Error(err)
+		}
+		if lease == nil {
+			logrus.Debugf("project %s has %d running jobs, deferring %s", project, max, t.job)
+			a.deferJob(ctx, sched, t, queue, "project_limit")
+			return false
+		}
+		t.leases = append(t.leases, lease)
+	}
+
+	lock := limit.Semaphore{Key: repoLockKeyPrefix + job.Target(), Limit: 1, TTL: leaseTTL}
+	lease, err := lock.Acquire(ctx, a.RedisClient, t.id)
+	if err != nil {
+		logrus.Error(err)
+	}
+	if lease == nil {
+		logrus.Debugf("repo %s is already running, deferring %s", job.Target(), t.job)
+		a.deferJob(ctx, sched, t, queue, "repo_locked")
+		return false
+	}
+	t.leases = append(t.leases, lease)
+
+	if a.MaxStartsPerMinute > 0 {
+		bucket := limit.TokenBucket{Key: startsKey, Rate: a.MaxStartsPerMinute}
+		wait, err := bucket.Take(ctx, a.RedisClient)
+		if err != nil {
+			logrus.Error(err)
+			wait = deferDelay
+		}
+		if wait > 0 {
+			logrus.Debugf("start rate limit reached, waiting %s before starting %s", wait, t.job)
+			deferredJobs.WithLabelValues("rate_limit").Inc()
+			a.pushBack(ctx, t, queue)
+			sleep(ctx, wait)
+			return false
+		}
+	}
+	return true
+}
+
+// pushBack releases the leases of t and puts its job back first in queue, it is not the job but the agents that should wait.
+func (a *Agent) pushBack(ctx context.Context, t *task, queue string) {
+	t.release()
+	err := a.RedisClient.LPush(ctx, queue, t.job).Err()
+	if err != nil {
+		logrus.Errorf("failed to push job %s back to %s, err: %s", t.job, queue, err)
+	}
+}
+
+// deferJob releases the leases of t, pushes its job back to the end of queue and skips the project of queue for a while.
+func (a *Agent) deferJob(ctx context.Context, sched *scheduler, t *task, queue string, reason string) {
+	deferredJobs.WithLabelValues(reason).Inc()
+	t.release()
+	err := a.RedisClient.RPush(ctx, queue, t.job).Err()
+	if err != nil {
+		logrus.Errorf("failed to push deferred job %s back to %s, err: %s", t.job, queue, err)
+	}
+	_, project := localredis.ParseQueueKey(queue)
+	sched.block(project, time.Now().Add(deferDelay))
+}
+
+// parseProjectValues parses values in the format project=number.
+func parseProjectValues(values []string) (map[string]int, error) {
+	parsed := make(map[string]int, len(values))
+	for _, value := range values {
+		project, number, ok := strings.Cut(value, "=")
+		if !ok || project == "" {
+			return nil, fmt.Errorf("invalid value: '%s', expected project=number", value)
+		}
+		n, err := strconv.Atoi(number)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number in '%s', err: %w", value, err)
+		}
+		parsed[project] = n
+	}
+	return parsed, nil
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
@@ -0,0 +1,130 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fortnoxab/renovator/mocks"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAdmit(t *testing.T) {
+	redisMock := mocks.NewMockCmdable(t)
+	a := &Agent{
+		RedisClient:   redisMock,
+		ProjectLimits: map[string]int{"big": 1, "*": 0},
+	}
+	sched := newScheduler("agent1", nil, nil)
+	ctx := context.Background()
+
+	redisMock.On("EvalSha", mock.Anything, mock.Anything, []string{"renovator-lock:small/repo1"}, mock.Anything, 1, mock.Anything, "run1", int64(60000)).
+		Return(redis.NewCmdResult(int64(1), nil)).
+		Once()
+	small := &task{id: "run1", job: "small/repo1"}
+	assert.True(t, a.admit(ctx, sched, small, "renovator-joblist:project=small"))
+	assert.Len(t, small.leases, 1)
+
+	redisMock.On("EvalSha", mock.Anything, mock.Anything, []string{"renovator-running:project=big"}, mock.Anything, 1, mock.Anything, "run2", int64(60000)).
+		Return(redis.NewCmdResult(int64(1), nil)).
+		Once()
+	redisMock.On("EvalSha", mock.Anything, mock.Anything, []string{"renovator-lock:big/repo1"}, mock.Anything, 1, mock.Anything, "run2", int64(60000)).
+		Return(redis.NewCmdResult(int64(1), nil)).
+		Once()
+	big := &task{id: "run2", job: "big/repo1"}
+	assert.True(t, a.admit(ctx, sched, big, "renovator-joblist:project=big"))
+	assert.Len(t, big.leases, 2)
+
+	redisMock.On("EvalSha", mock.Anything, mock.Anything, []string{"renovator-running:project=big"}, mock.Anything, 1, mock.Anything, "run3", int64(60000)).
+		Return(redis.NewCmdResult(int64(0), nil)).
+		Once()
+	redisMock.On("RPush", mock.Anything, "renovator-joblist:project=big", "big/repo2").
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+	assert.False(t, a.admit(ctx, sched, &task{id: "run3", job: "big/repo2"}, "renovator-joblist:project=big"))
+	assert.Equal(t, []string{"renovator-joblist:project=small"}, sched.order([]string{"renovator-joblist:project=big", "renovator-joblist:project=small"}, time.Now()))
+}
+
+func TestAdmitGlobalLimits(t *testing.T) {
+	redisMock := mocks.NewMockCmdable(t)
+	a := &Agent{
+		RedisClient:        redisMock,
+		ClusterMaxRunning:  2,
+		MaxStartsPerMinute: 600,
+	}
+	sched := newScheduler("agent1", nil, nil)
+	ctx := context.Background()
+
+	// the job is put back first in the queue when the cluster is full
+	redisMock.On("EvalSha", mock.Anything, mock.Anything, []string{"renovator-running:all"}, mock.Anything, 2, mock.Anything, "run2", int64(60000)).
+		Return(redis.NewCmdResult(int64(0), nil)).
+		Once()
+	redisMock.On("LPush", mock.Anything, "renovator-joblist", "project1/repo2").
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+	assert.False(t, a.admit(ctx, sched, &task{id: "run2", job: "project1/repo2"}, "renovator-joblist"))
+
+	redisMock.On("EvalSha", mock.Anything, mock.Anything, []string{"renovator-running:all"}, mock.Anything, 2, mock.Anything, "run1", int64(60000)).
+		Return(redis.NewCmdResult(int64(1), nil)).
+		Once()
+	redisMock.On("EvalSha", mock.Anything, mock.Anything, []string{"renovator-lock:project1/repo1"}, mock.Anything, 1, mock.Anything, "run1", int64(60000)).
+		Return(redis.NewCmdResult(int64(1), nil)).
+		Once()
+	redisMock.On("ZRem", mock.Anything, "renovator-lock:project1/repo1", "run1").
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+	redisMock.On("EvalSha", mock.Anything, mock.Anything, []string{"renovator-starts"}, "0.01", 600, mock.Anything).
+		Return(redis.NewCmdResult(int64(10), nil)).
+		Once()
+	redisMock.On("ZRem", mock.Anything, "renovator-running:all", "run1").
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+	redisMock.On("LPush", mock.Anything, "renovator-joblist", "project1/repo1").
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+	assert.False(t, a.admit(ctx, sched, &task{id: "run1", job: "project1/repo1"}, "renovator-joblist"))
+}
+
+func TestAdmitRepoLocked(t *testing.T) {
+	redisMock := mocks.NewMockCmdable(t)
+	a := &Agent{RedisClient: redisMock}
+	sched := newScheduler("agent1", nil, nil)
+
+	redisMock.On("EvalSha", mock.Anything, mock.Anything, []string{"renovator-lock:project1/repo1"}, mock.Anything, 1, mock.Anything, "run1", int64(60000)).
+		Return(redis.NewCmdResult(int64(0), nil)).
+		Once()
+	redisMock.On("RPush", mock.Anything, "renovator-joblist:project=project1", "project1/repo1").
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+	assert.False(t, a.admit(context.Background(), sched, &task{id: "run1", job: "project1/repo1"}, "renovator-joblist:project=project1"))
+}
+
+func TestAdmitRepoLockPerPlatform(t *testing.T) {
+	redisMock := mocks.NewMockCmdable(t)
+	a := &Agent{RedisClient: redisMock}
+	sched := newScheduler("agent1", nil, nil)
+	ctx := context.Background()
+
+	gitlab := "group1/repo1?endpoint=https%3A%2F%2Fgitlab.example.com%2Fapi%2Fv4&platform=gitlab"
+	redisMock.On("EvalSha", mock.Anything, mock.Anything, []string{"renovator-lock:" + gitlab}, mock.Anything, 1, mock.Anything, "run1", int64(60000)).
+		Return(redis.NewCmdResult(int64(1), nil)).
+		Once()
+	assert.True(t, a.admit(ctx, sched, &task{id: "run1", job: gitlab + "&dryrun=lookup"}, "renovator-joblist"))
+
+	github := "group1/repo1?endpoint=https%3A%2F%2Fapi.github.com&platform=github"
+	redisMock.On("EvalSha", mock.Anything, mock.Anything, []string{"renovator-lock:" + github}, mock.Anything, 1, mock.Anything, "run2", int64(60000)).
+		Return(redis.NewCmdResult(int64(1), nil)).
+		Once()
+	assert.True(t, a.admit(ctx, sched, &task{id: "run2", job: github}, "renovator-joblist"))
+}
+
+func TestParseProjectValues(t *testing.T) {
+	values, err := parseProjectValues([]string{"big=3", "*=1"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"big": 3, "*": 1}, values)
+
+	_, err = parseProjectValues([]string{"big"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,111 @@
+package agent
+
+import (
+	"slices"
+	"sort"
+	"time"
+
+	localredis "github.com/fortnoxab/renovator/pkg/redis"
+)
+
+// scheduler orders the queues the agent takes jobs from so projects are served round-robin.
+// A project with weight n gets n jobs in a row before the next project is served.
+// Queues of jobs assigned to other agents are skipped and the agent's own queues are preferred.
+type scheduler struct {
+	agent   string
+	labels  []string
+	weights map[string]int
+	blocked map[string]time.Time
+	paused  []string
+	current string
+	served  int
+}
+
+func newScheduler(agent string, labels []string, weights map[string]int) *scheduler {
+	return &scheduler{agent: agent, labels: labels, weights: weights, blocked: make(map[string]time.Time)}
+}
+
+// order returns the queues the agent can run jobs from, starting with the project in turn.
+func (s *scheduler) order(keys []string, now time.Time) []string {
+	var projects []string
+	queues := make(map[string][]string)
+	for _, key := range keys {
+		if agent := localredis.QueueAgent(key); agent != "" && agent != s.agent {
+			continue
+		}
+		labels, project := localredis.ParseQueueKey(key)
+		if !subset(labels, s.labels) {
+			continue
+		}
+		if until, ok := s.blocked[project]; ok && now.Before(until) {
+			continue
+		}
+		if project != "" && slices.Contains(s.paused, project) {
+			continue
+		}
+		if _, ok := queues[project]; !ok {
+			projects = append(projects, project)
+		}
+		queues[project] = append(queues[project], key)
+	}
+	if len(projects) == 0 {
+		return nil
+	}
+	sort.Strings(projects)
+
+	start := sort.SearchStrings(projects, s.current)
+	if start < len(projects) && projects[start] == s.current && s.served >= s.weight(s.current) {
+		start++
+	}
+
+	var ordered []string
+	for i := range projects {
+		project := projects[(start+i)%len(projects)]
+		// Prefer jobs assigned to this agent and then jobs that agents with less labels can't run.
+		slices.SortStableFunc(queues[project], func(a, b string) int {
+			if aa, ab := localredis.QueueAgent(a) != "", localredis.QueueAgent(b) != ""; aa != ab {
+				if aa {
+					return -1
+				}
+				return 1
+			}
+			la, _ := localredis.ParseQueueKey(a)
+			lb, _ := localredis.ParseQueueKey(b)
+			return len(lb) - len(la)
+		})
+		ordered = append(ordered, queues[project]...)
+	}
+	return ordered
+}
+
+// popped records that a job was taken from queue.
+func (s *scheduler) popped(queue string) {
+	_, project := localredis.ParseQueueKey(queue)
+	if project == s.current {
+		s.served++
+		return
+	}
+	s.current = project
+	s.served = 1
+}
+
+// block skips the queues of project until the given time.
+func (s *scheduler) block(project string, until time.Time) {
+	s.blocked[project] = until
+}
+
+func (s *scheduler) weight(project string) int {
+	if w := s.weights[project]; w > 0 {
+		return w
+	}
+	return 1
+}
+
+func subset(labels []string, of []string) bool {
+	for _, label := range labels {
+		if !slices.Contains(of, label) {
+			return false
+		}
+	}
+	return true
+}
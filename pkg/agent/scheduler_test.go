@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedulerRoundRobin(t *testing.T) {
+	s := newScheduler("agent1", nil, map[string]int{"big": 2})
+	keys := []string{
+		"renovator-joblist",
+		"renovator-joblist:labels=java:project=small",
+		"renovator-joblist:project=big",
+		"renovator-joblist:project=small",
+	}
+	now := time.Now()
+
+	assert.Equal(t, []string{"renovator-joblist", "renovator-joblist:project=big", "renovator-joblist:project=small"}, s.order(keys, now))
+
+	s.popped("renovator-joblist:project=big")
+	assert.Equal(t, []string{"renovator-joblist:project=big", "renovator-joblist:project=small", "renovator-joblist"}, s.order(keys, now))
+
+	s.popped("renovator-joblist:project=big")
+	assert.Equal(t, []string{"renovator-joblist:project=small", "renovator-joblist", "renovator-joblist:project=big"}, s.order(keys, now))
+
+	s.popped("renovator-joblist:project=small")
+	assert.Equal(t, []string{"renovator-joblist", "renovator-joblist:project=big", "renovator-joblist:project=small"}, s.order(keys, now))
+}
+
+func TestSchedulerLabels(t *testing.T) {
+	s := newScheduler("agent1", []string{"java"}, nil)
+	keys := []string{
+		"renovator-joblist",
+		"renovator-joblist:labels=full",
+		"renovator-joblist:labels=java",
+		"renovator-joblist:labels=java:project=small",
+		"renovator-joblist:project=small",
+	}
+
+	assert.Equal(t, []string{
+		"renovator-joblist:labels=java",
+		"renovator-joblist",
+		"renovator-joblist:labels=java:project=small",
+		"renovator-joblist:project=small",
+	}, s.order(keys, time.Now()))
+}
+
+func TestSchedulerBlocked(t *testing.T) {
+	s := newScheduler("agent1", nil, nil)
+	keys := []string{"renovator-joblist:project=big", "renovator-joblist:project=small"}
+	now := time.Now()
+
+	s.block("big", now.Add(time.Second))
+	assert.Equal(t, []string{"renovator-joblist:project=small"}, s.order(keys, now))
+	assert.Equal(t, keys, s.order(keys, now.Add(2*time.Second)))
+}
+
+func TestSchedulerPaused(t *testing.T) {
+	s := newScheduler("agent1", nil, nil)
+	s.paused = []string{"big"}
+	keys := []string{"renovator-joblist", "renovator-joblist:project=big", "renovator-joblist:project=small"}
+
+	assert.Equal(t, []string{"renovator-joblist", "renovator-joblist:project=small"}, s.order(keys, time.Now()))
+}
+
+func TestSchedulerAffinity(t *testing.T) {
+	s := newScheduler("agent1", nil, nil)
+	keys := []string{
+		"renovator-joblist",
+		"renovator-joblist:agent=agent1",
+		"renovator-joblist:agent=agent2",
+		"renovator-joblist:project=small",
+		"renovator-joblist:project=small:agent=agent1",
+	}
+
+	assert.Equal(t, []string{
+		"renovator-joblist:agent=agent1",
+		"renovator-joblist",
+		"renovator-joblist:project=small:agent=agent1",
+		"renovator-joblist:project=small",
+	}, s.order(keys, time.Now()))
+}
@@ -0,0 +1,57 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+// Bucket stores objects by key.
+type Bucket interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get returns ErrNotFound if the object does not exist.
+	Get(ctx context.Context, key string) (*Object, error)
+	Delete(ctx context.Context, key string) error
+}
+
+type Object struct {
+	Body    io.ReadCloser
+	Size    int64
+	ModTime time.Time
+}
+
+// New returns a Bucket from a url, ex: file:///var/lib/renovator or s3://bucket/prefix
+func New(rawURL string, s3Config S3Config) (Bucket, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing store url: %w", err)
+	}
+
+	switch u.Scheme {
+	case "file":
+		return &Dir{Path: u.Path}, nil
+	case "s3":
+		s3Config.Bucket = u.Host
+		s3Config.Prefix = strings.TrimPrefix(u.Path, "/")
+		return NewS3(s3Config), nil
+	default:
+		return nil, fmt.Errorf("unsupported store url: '%s', must start with file:// or s3://", rawURL)
+	}
+}
+
+func S3ConfigFromContext(cCtx *cli.Context) S3Config {
+	return S3Config{
+		Endpoint:  cCtx.String("s3-endpoint"),
+		Region:    cCtx.String("s3-region"),
+		AccessKey: cCtx.String("s3-access-key"),
+		SecretKey: cCtx.String("s3-secret-key"),
+	}
+}
@@ -0,0 +1,106 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeS3 is a minimal stand-in for a S3 compatible server like minio.
+type fakeS3 struct {
+	t       *testing.T
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	assert.True(f.t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/"), r.Header.Get("Authorization"))
+	assert.NotEmpty(f.t, r.Header.Get("X-Amz-Date"))
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		b, err := io.ReadAll(r.Body)
+		assert.NoError(f.t, err)
+		f.objects[r.URL.Path] = b
+	case http.MethodGet:
+		b, ok := f.objects[r.URL.Path]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		_, _ = w.Write(b)
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func testBucket(t *testing.T, bucket Bucket) {
+	ctx := context.Background()
+
+	_, err := bucket.Get(ctx, "project1/repo1/1.log")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	err = bucket.Put(ctx, "project1/repo1/1.log", bytes.NewBufferString("log data"), 8)
+	assert.NoError(t, err)
+
+	obj, err := bucket.Get(ctx, "project1/repo1/1.log")
+	assert.NoError(t, err)
+	b, err := io.ReadAll(obj.Body)
+	assert.NoError(t, err)
+	assert.NoError(t, obj.Body.Close())
+	assert.Equal(t, "log data", string(b))
+	assert.WithinDuration(t, time.Now(), obj.ModTime, 2*time.Second)
+
+	err = bucket.Delete(ctx, "project1/repo1/1.log")
+	assert.NoError(t, err)
+	_, err = bucket.Get(ctx, "project1/repo1/1.log")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestDir(t *testing.T) {
+	dir := t.TempDir()
+	testBucket(t, &Dir{Path: dir})
+
+	_, err := (&Dir{Path: dir}).Get(context.Background(), "../outside")
+	assert.ErrorContains(t, err, "invalid key")
+}
+
+func TestDirDeleteOlderThan(t *testing.T) {
+	dir := t.TempDir()
+	d := &Dir{Path: dir}
+	assert.NoError(t, d.Put(context.Background(), "old/1.log", bytes.NewBufferString("old"), 3))
+	assert.NoError(t, d.Put(context.Background(), "new/1.log", bytes.NewBufferString("new"), 3))
+	old := time.Now().Add(-2 * time.Hour)
+	assert.NoError(t, os.Chtimes(filepath.Join(dir, "old/1.log"), old, old))
+
+	assert.NoError(t, d.DeleteOlderThan(time.Hour))
+	assert.NoFileExists(t, filepath.Join(dir, "old/1.log"))
+	assert.FileExists(t, filepath.Join(dir, "new/1.log"))
+}
+
+func TestS3(t *testing.T) {
+	fake := &fakeS3{t: t, objects: map[string][]byte{}}
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+
+	bucket, err := New("s3://renovator/logs", S3Config{Endpoint: ts.URL, AccessKey: "access", SecretKey: "secret"})
+	assert.NoError(t, err)
+	testBucket(t, bucket)
+
+	assert.NoError(t, bucket.Put(context.Background(), "a b+c", bytes.NewBufferString("x"), 1))
+	assert.Contains(t, fake.objects, "/renovator/logs/a b+c")
+}
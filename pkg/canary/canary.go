@@ -0,0 +1,169 @@
+package canary
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/fortnoxab/renovator/pkg/history"
+	"github.com/fortnoxab/renovator/pkg/renovate"
+	"github.com/redis/go-redis/v9"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	GroupCanary = "canary"
+	GroupStable = "stable"
+
+	// redisKeyPrefix is followed by the group and the hour of the hashes counting runs by status.
+	redisKeyPrefix = "renovator-canary:"
+	// Retention is how long the run counts are kept, it is the longest window that can be compared.
+	Retention = 8 * 24 * time.Hour
+)
+
+// Policy routes a percent of the repos and the repos matching patterns to the agents with Label.
+type Policy struct {
+	Label   string
+	Percent float64
+	Repos   []string
+}
+
+// NewPolicyFromContext reads the --canary-label, --canary-percent and --canary-repos flags.
+func NewPolicyFromContext(cCtx *cli.Context) (Policy, error) {
+	p := Policy{
+		Label:   cCtx.String("canary-label"),
+		Percent: cCtx.Float64("canary-percent"),
+		Repos:   cCtx.StringSlice("canary-repos"),
+	}
+	if p.Percent < 0 || p.Percent > 100 {
+		return Policy{}, fmt.Errorf("invalid --canary-percent: %g, must be between 0 and 100", p.Percent)
+	}
+	for _, pattern := range p.Repos {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return Policy{}, fmt.Errorf("invalid --canary-repos pattern: '%s', err: %w", pattern, err)
+		}
+	}
+	if p.Enabled() && p.Label == "" {
+		return Policy{}, errors.New("--canary-label is required to route repos to canary agents")
+	}
+	return p, nil
+}
+
+// Enabled is true if the policy routes any repos to the canary agents.
+func (p Policy) Enabled() bool {
+	return p.Percent > 0 || len(p.Repos) > 0
+}
+
+// Picks returns true if jobs for repo should run on the canary agents. The same repos are picked every time
+// and they stay picked when the percent is increased.
+func (p Policy) Picks(repo string) bool {
+	for _, pattern := range p.Repos {
+		if ok, _ := path.Match(pattern, repo); ok {
+			return true
+		}
+	}
+	// the percentile is salted so it is independent of the renovate version rollouts
+	return p.Percent > 0 && renovate.Percentile("canary:"+repo) < p.Percent
+}
+
+func statsKey(group string, hour time.Time) string {
+	return redisKeyPrefix + group + ":" + hour.UTC().Format("2006010215")
+}
+
+// Record counts a finished run with status in group at the time it finished.
+func Record(ctx context.Context, redisClient redis.Cmdable, group string, status string, finished time.Time) error {
+	key := statsKey(group, finished)
+	err := redisClient.HIncrBy(ctx, key, status, 1).Err()
+	if err != nil {
+		return fmt.Errorf("error counting %s run: %w", group, err)
+	}
+	err = redisClient.Expire(ctx, key, Retention).Err()
+	if err != nil {
+		return fmt.Errorf("error setting ttl on %s: %w", key, err)
+	}
+	return nil
+}
+
+// Stats is the number of finished runs in a group, errors are runs that did not finish ok.
+type Stats struct {
+	Runs      int     `json:"runs"`
+	Errors    int     `json:"errors"`
+	ErrorRate float64 `json:"errorRate"`
+}
+
+// Report compares the canary runs with the stable runs.
+type Report struct {
+	Since  time.Time `json:"since"`
+	Canary Stats     `json:"canary"`
+	Stable Stats     `json:"stable"`
+	// Regression is true if the error rate of the canary runs is more than the threshold above the stable runs.
+	Regression bool `json:"regression"`
+}
+
+// Comparison decides when canary runs are a regression.
+type Comparison struct {
+	// Window is how far back runs are compared, it is rounded to whole hours.
+	Window time.Duration
+	// Threshold is how much higher the error rate of canary runs can be than that of stable runs, ex 0.05.
+	Threshold float64
+	// MinRuns is the number of runs both groups need before they are compared.
+	MinRuns int
+}
+
+// NewComparisonFromContext reads the --canary-window, --canary-threshold and --canary-min-runs flags.
+func NewComparisonFromContext(cCtx *cli.Context) (Comparison, error) {
+	c := Comparison{
+		Window:    cCtx.Duration("canary-window"),
+		Threshold: cCtx.Float64("canary-threshold"),
+		MinRuns:   cCtx.Int("canary-min-runs"),
+	}
+	if c.Window <= 0 || c.Window > Retention {
+		return Comparison{}, fmt.Errorf("invalid --canary-window: %s, must be at most %s", c.Window, Retention)
+	}
+	return c, nil
+}
+
+// Compare returns the report of the runs that finished in the window before now.
+func (c Comparison) Compare(ctx context.Context, redisClient redis.Cmdable, now time.Time) (*Report, error) {
+	since := now.Add(-c.Window).Truncate(time.Hour)
+	report := &Report{Since: since}
+	var err error
+	report.Canary, err = stats(ctx, redisClient, GroupCanary, since, now)
+	if err != nil {
+		return nil, err
+	}
+	report.Stable, err = stats(ctx, redisClient, GroupStable, since, now)
+	if err != nil {
+		return nil, err
+	}
+	report.Regression = report.Canary.Runs >= c.MinRuns && report.Stable.Runs >= c.MinRuns &&
+		report.Canary.ErrorRate-report.Stable.ErrorRate > c.Threshold
+	return report, nil
+}
+
+func stats(ctx context.Context, redisClient redis.Cmdable, group string, since time.Time, now time.Time) (Stats, error) {
+	s := Stats{}
+	for hour := since; !hour.After(now); hour = hour.Add(time.Hour) {
+		counts, err := redisClient.HGetAll(ctx, statsKey(group, hour)).Result()
+		if err != nil && err != redis.Nil {
+			return Stats{}, fmt.Errorf("error getting %s runs: %w", group, err)
+		}
+		for status, count := range counts {
+			n, err := strconv.Atoi(count)
+			if err != nil {
+				return Stats{}, fmt.Errorf("error parsing %s runs with status %s: %w", group, status, err)
+			}
+			s.Runs += n
+			if status != history.StatusOK {
+				s.Errors += n
+			}
+		}
+	}
+	if s.Runs > 0 {
+		s.ErrorRate = float64(s.Errors) / float64(s.Runs)
+	}
+	return s, nil
+}
@@ -0,0 +1,74 @@
+package canary
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fortnoxab/renovator/mocks"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPolicy(t *testing.T) {
+	p := Policy{Label: "canary", Repos: []string{"project1/*"}}
+	assert.True(t, p.Enabled())
+	assert.True(t, p.Picks("project1/repo1"))
+	assert.False(t, p.Picks("project2/repo1"))
+	assert.False(t, Policy{Label: "canary"}.Enabled())
+
+	p = Policy{Label: "canary", Percent: 10}
+	picked := 0
+	for i := range 1000 {
+		repo := fmt.Sprintf("project2/repo%d", i)
+		if p.Picks(repo) {
+			picked++
+			assert.True(t, Policy{Label: "canary", Percent: 50}.Picks(repo))
+		}
+	}
+	assert.InDelta(t, 100, picked, 40)
+}
+
+func TestRecord(t *testing.T) {
+	redisMock := mocks.NewMockCmdable(t)
+	finished := time.Date(2024, 5, 1, 13, 45, 0, 0, time.UTC)
+	redisMock.On("HIncrBy", mock.Anything, "renovator-canary:canary:2024050113", "error", int64(1)).
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+	redisMock.On("Expire", mock.Anything, "renovator-canary:canary:2024050113", Retention).
+		Return(redis.NewBoolResult(true, nil)).
+		Once()
+
+	assert.NoError(t, Record(context.Background(), redisMock, GroupCanary, "error", finished))
+}
+
+func TestCompare(t *testing.T) {
+	redisMock := mocks.NewMockCmdable(t)
+	now := time.Date(2024, 5, 1, 13, 45, 0, 0, time.UTC)
+	counts := map[string]map[string]string{
+		"renovator-canary:canary:2024050112": {"ok": "20", "error": "3"},
+		"renovator-canary:canary:2024050113": {"ok": "5", "oom": "2"},
+		"renovator-canary:stable:2024050111": {"ok": "1000"},
+		"renovator-canary:stable:2024050112": {"ok": "380", "error": "20"},
+	}
+	redisMock.On("HGetAll", mock.Anything, mock.Anything).
+		Return(func(_ context.Context, key string) *redis.MapStringStringCmd {
+			return redis.NewMapStringStringResult(counts[key], nil)
+		}).
+		Times(12)
+
+	c := Comparison{Window: 2 * time.Hour, Threshold: 0.05, MinRuns: 20}
+	report, err := c.Compare(context.Background(), redisMock, now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC), report.Since)
+	assert.Equal(t, Stats{Runs: 30, Errors: 5, ErrorRate: 5.0 / 30}, report.Canary)
+	assert.Equal(t, Stats{Runs: 1400, Errors: 20, ErrorRate: 20.0 / 1400}, report.Stable)
+	assert.True(t, report.Regression)
+
+	c.MinRuns = 50
+	report, err = c.Compare(context.Background(), redisMock, now)
+	assert.NoError(t, err)
+	assert.False(t, report.Regression)
+}
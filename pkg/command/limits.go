@@ -0,0 +1,45 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrOOMKilled is returned when the kernel killed the command for using more memory than its limit.
+var ErrOOMKilled = errors.New("killed by the kernel for running out of memory")
+
+// Limits are resource limits applied to every command started by Exec.
+type Limits struct {
+	// Memory is the maximum number of bytes of memory.
+	Memory int64
+	// CPU is the maximum number of cpus, ex 1.5.
+	CPU float64
+	// CgroupRoot is a cgroup v2 directory delegated to the agent, each command is placed in its own cgroup below it
+	// when it is started. Without it the limits are ignored.
+	CgroupRoot string
+}
+
+func (l Limits) enabled() bool {
+	return l.Memory > 0 || l.CPU > 0
+}
+
+// ParseBytes parses a size like 512M or 2G into bytes.
+func ParseBytes(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	units := map[byte]int64{'K': 1 << 10, 'M': 1 << 20, 'G': 1 << 30, 'T': 1 << 40}
+	multiplier := int64(1)
+	number := strings.TrimSuffix(strings.ToUpper(s), "B")
+	if unit, ok := units[number[len(number)-1]]; ok {
+		multiplier = unit
+		number = number[:len(number)-1]
+	}
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: '%s', expected ex 512M or 2G", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
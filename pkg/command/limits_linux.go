@@ -0,0 +1,131 @@
+package command
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// cpuPeriod is the cgroup cpu.max period in microseconds.
+const cpuPeriod = 100000
+
+// cgroup is the cgroup v2 a single command runs in.
+type cgroup struct {
+	path string
+	dir  *os.File
+}
+
+// applyLimits places cmd in a new cgroup if a cgroup root is configured.
+func (e *Exec) applyLimits(cmd *exec.Cmd) (*cgroup, error) {
+	if !e.Limits.enabled() || e.Limits.CgroupRoot == "" {
+		return nil, nil
+	}
+
+	cg, err := newCgroup(e.Limits.CgroupRoot, e.Limits)
+	if err != nil {
+		return nil, err
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{UseCgroupFD: true, CgroupFD: int(cg.dir.Fd())}
+	return cg, nil
+}
+
+func newCgroup(root string, limits Limits) (*cgroup, error) {
+	var controllers []string
+	if limits.Memory > 0 {
+		controllers = append(controllers, "+memory")
+	}
+	if limits.CPU > 0 {
+		controllers = append(controllers, "+cpu")
+	}
+	err := writeCgroupFile(filepath.Join(root, "cgroup.subtree_control"), strings.Join(controllers, " "))
+	if err != nil {
+		return nil, fmt.Errorf("error enabling cgroup controllers in %s, err: %w", root, err)
+	}
+
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	path := filepath.Join(root, "renovate-"+hex.EncodeToString(b))
+	err = os.Mkdir(path, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("error creating cgroup, err: %w", err)
+	}
+	cg := &cgroup{path: path}
+
+	files := map[string]string{}
+	if limits.Memory > 0 {
+		files["memory.max"] = strconv.FormatInt(limits.Memory, 10)
+		files["memory.swap.max"] = "0"
+	}
+	if limits.CPU > 0 {
+		files["cpu.max"] = fmt.Sprintf("%d %d", int64(limits.CPU*cpuPeriod), cpuPeriod)
+	}
+	for name, value := range files {
+		err = writeCgroupFile(filepath.Join(path, name), value)
+		if err != nil && !(name == "memory.swap.max" && errors.Is(err, os.ErrNotExist)) {
+			_ = cg.remove()
+			return nil, fmt.Errorf("error writing %s in cgroup %s, err: %w", name, path, err)
+		}
+	}
+
+	cg.dir, err = os.Open(path)
+	if err != nil {
+		_ = cg.remove()
+		return nil, fmt.Errorf("error opening cgroup %s, err: %w", path, err)
+	}
+	return cg, nil
+}
+
+// oomKilled returns true if the kernel killed a process in the cgroup for running out of memory.
+func (c *cgroup) oomKilled() bool {
+	f, err := os.Open(filepath.Join(c.path, "memory.events"))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, value, _ := strings.Cut(scanner.Text(), " ")
+		if name == "oom_kill" {
+			n, _ := strconv.Atoi(value)
+			return n > 0
+		}
+	}
+	return false
+}
+
+// remove kills processes left in the cgroup and removes it.
+func (c *cgroup) remove() error {
+	if c.dir != nil {
+		c.dir.Close()
+	}
+	// cgroup.kill is missing before linux 5.14, the command has exited and usually its children too
+	_ = writeCgroupFile(filepath.Join(c.path, "cgroup.kill"), "1")
+	// the interface files of a cgroup can't be removed, rmdir removes the directory with them
+	err := syscall.Rmdir(c.path)
+	if err != nil {
+		return fmt.Errorf("error removing cgroup %s, err: %w", c.path, err)
+	}
+	return nil
+}
+
+// writeCgroupFile writes to an existing cgroup interface file.
+func writeCgroupFile(path string, value string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(value)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
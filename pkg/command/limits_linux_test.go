@@ -0,0 +1,33 @@
+package command
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCgroupOOMKilled(t *testing.T) {
+	cg := &cgroup{path: t.TempDir()}
+	assert.False(t, cg.oomKilled())
+
+	err := os.WriteFile(filepath.Join(cg.path, "memory.events"), []byte("low 0\nhigh 0\nmax 3\noom 1\noom_kill 0\n"), 0o644)
+	assert.NoError(t, err)
+	assert.False(t, cg.oomKilled())
+
+	err = os.WriteFile(filepath.Join(cg.path, "memory.events"), []byte("low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n"), 0o644)
+	assert.NoError(t, err)
+	assert.True(t, cg.oomKilled())
+}
+
+func TestApplyLimitsWithoutCgroupRoot(t *testing.T) {
+	e := &Exec{Limits: Limits{Memory: 512 << 20}}
+	cmd := exec.Command("true")
+
+	cg, err := e.applyLimits(cmd)
+	assert.NoError(t, err)
+	assert.Nil(t, cg)
+	assert.Nil(t, cmd.SysProcAttr)
+}
@@ -0,0 +1,32 @@
+//go:build !linux
+
+package command
+
+import (
+	"os/exec"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+type cgroup struct{}
+
+var warnLimits sync.Once
+
+// applyLimits runs cmd without limits, they are only supported on linux.
+func (e *Exec) applyLimits(cmd *exec.Cmd) (*cgroup, error) {
+	if e.Limits.enabled() {
+		warnLimits.Do(func() {
+			logrus.Warn("limits of commands are only supported on linux, they are ignored")
+		})
+	}
+	return nil, nil
+}
+
+func (c *cgroup) oomKilled() bool {
+	return false
+}
+
+func (c *cgroup) remove() error {
+	return nil
+}
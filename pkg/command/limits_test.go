@@ -0,0 +1,25 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBytes(t *testing.T) {
+	for s, expected := range map[string]int64{
+		"":     0,
+		"1024": 1024,
+		"512M": 512 << 20,
+		"2G":   2 << 30,
+		"1.5g": 3 << 29,
+		"64KB": 64 << 10,
+	} {
+		n, err := ParseBytes(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, expected, n, s)
+	}
+
+	_, err := ParseBytes("lots")
+	assert.Error(t, err)
+}
@@ -0,0 +1,128 @@
+package command
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// reapInterval is how often orphans are reaped if a SIGCHLD is missed.
+const reapInterval = 10 * time.Second
+
+// reaper reaps orphaned processes re-parented to us, ex children of renovate that outlive it.
+// It leaves the exit status of commands started by Exec to their cmd.Wait.
+type reaper struct {
+	// starting is held for reading while commands are started so every child that exists when
+	// the reaper holds it for writing is tracked.
+	starting sync.RWMutex
+	mu       sync.Mutex
+	owned    map[int]struct{}
+}
+
+var (
+	defaultReaper *reaper
+	reaperOnce    sync.Once
+)
+
+func getReaper() *reaper {
+	reaperOnce.Do(func() {
+		defaultReaper = &reaper{owned: make(map[int]struct{})}
+		if os.Getpid() != 1 {
+			err := unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0)
+			if err != nil {
+				logrus.Errorf("error becoming child subreaper: %s", err)
+			}
+		}
+		go defaultReaper.run()
+	})
+	return defaultReaper
+}
+
+// start starts cmd and tracks it until done is called.
+func (r *reaper) start(cmd *exec.Cmd) error {
+	r.starting.RLock()
+	defer r.starting.RUnlock()
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.owned[cmd.Process.Pid] = struct{}{}
+	r.mu.Unlock()
+	return nil
+}
+
+// done stops tracking a command after cmd.Wait has returned.
+func (r *reaper) done(pid int) {
+	r.mu.Lock()
+	delete(r.owned, pid)
+	r.mu.Unlock()
+}
+
+func (r *reaper) isOwned(pid int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.owned[pid]
+	return ok
+}
+
+func (r *reaper) run() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGCHLD)
+	ticker := time.NewTicker(reapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sigs:
+		case <-ticker.C:
+		}
+		r.reap()
+	}
+}
+
+// reap reaps exited children that are not owned by a command.
+func (r *reaper) reap() {
+	r.starting.Lock()
+	defer r.starting.Unlock()
+	for {
+		pid, err := peekExited()
+		if err != nil || pid == 0 {
+			return
+		}
+		if r.isOwned(pid) {
+			// cmd.Wait reaps it, the next exited child is visible after that
+			return
+		}
+
+		var wstatus syscall.WaitStatus
+		_, err = syscall.Wait4(pid, &wstatus, syscall.WNOHANG, nil)
+		if err != nil {
+			logrus.Errorf("error reaping orphan %d: %s", pid, err)
+			return
+		}
+		logrus.Debugf("reaped orphan %d %d", pid, wstatus)
+	}
+}
+
+// peekExited returns the pid of an exited child without reaping it, 0 if there is none.
+func peekExited() (int, error) {
+	var info [128]byte // siginfo_t
+	_, _, errno := syscall.Syscall6(syscall.SYS_WAITID, unix.P_ALL, 0, uintptr(unsafe.Pointer(&info[0])),
+		unix.WEXITED|unix.WNOHANG|unix.WNOWAIT, 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	// si_pid follows si_signo, si_errno and si_code, aligned to 8 bytes on 64 bit platforms
+	offset := 12
+	if unsafe.Sizeof(uintptr(0)) == 8 {
+		offset = 16
+	}
+	return int(*(*int32)(unsafe.Pointer(&info[offset]))), nil
+}
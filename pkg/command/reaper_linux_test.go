@@ -0,0 +1,78 @@
+package command
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunConcurrentChildren(t *testing.T) {
+	e := &Exec{}
+	wg := &sync.WaitGroup{}
+	errs := make(chan error, 50)
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// the backgrounded sleep is orphaned when sh exits and re-parented to us
+			output := &bytes.Buffer{}
+			errs <- e.RunWithOutput(output, nil, "sh", "-c", "sleep 0.0"+strconv.Itoa(i%10)+" & echo "+strconv.Itoa(i))
+			assert.Equal(t, strconv.Itoa(i)+"\n", output.String())
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	assert.Eventually(t, func() bool {
+		return len(zombies(t)) == 0
+	}, 5*time.Second, 50*time.Millisecond, "orphans should be reaped")
+}
+
+func TestRunExitStatus(t *testing.T) {
+	e := &Exec{}
+	wg := &sync.WaitGroup{}
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := e.Run("sh", "-c", "exit "+strconv.Itoa(i%2))
+			if i%2 == 0 {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, "exit status 1")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// zombies returns the exited children of the test process that are not reaped.
+func zombies(t *testing.T) []string {
+	stats, err := filepath.Glob("/proc/[0-9]*/stat")
+	assert.NoError(t, err)
+	ppid := strconv.Itoa(os.Getpid())
+
+	var found []string
+	for _, stat := range stats {
+		b, err := os.ReadFile(stat)
+		if err != nil {
+			continue
+		}
+		// pid (comm) state ppid ...
+		fields := strings.Fields(string(b[bytes.LastIndexByte(b, ')')+1:]))
+		if len(fields) > 1 && fields[0] == "Z" && fields[1] == ppid {
+			found = append(found, stat)
+		}
+	}
+	return found
+}
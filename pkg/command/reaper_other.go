@@ -0,0 +1,17 @@
+//go:build !linux
+
+package command
+
+import "os/exec"
+
+type reaper struct{}
+
+func getReaper() *reaper {
+	return &reaper{}
+}
+
+func (r *reaper) start(cmd *exec.Cmd) error {
+	return cmd.Start()
+}
+
+func (r *reaper) done(pid int) {}
@@ -1,11 +1,11 @@
 package command
 
 import (
-	"errors"
+	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"strings"
-	"syscall"
 
 	"github.com/sirupsen/logrus"
 )
@@ -13,9 +13,11 @@ import (
 type Commander interface {
 	Run(head string, parts ...string) error
 	RunWithEnv(env []string, head string, parts ...string) error
+	RunWithOutput(output io.Writer, env []string, head string, parts ...string) error
 }
 
 type Exec struct {
+	Limits Limits
 }
 
 func (e *Exec) Run(head string, parts ...string) (err error) {
@@ -23,13 +25,35 @@ func (e *Exec) Run(head string, parts ...string) (err error) {
 }
 
 func (e *Exec) RunWithEnv(env []string, head string, parts ...string) (err error) {
+	return e.run(os.Stdout, os.Stderr, env, head, parts...)
+}
+
+// RunWithOutput writes both stdout and stderr of the command to output.
+func (e *Exec) RunWithOutput(output io.Writer, env []string, head string, parts ...string) (err error) {
+	return e.run(output, output, env, head, parts...)
+}
+
+func (e *Exec) run(stdout io.Writer, stderr io.Writer, env []string, head string, parts ...string) (err error) {
 	cmd := exec.Command(head, parts...) // #nosec
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 	cmd.Env = os.Environ()
 	cmd.Env = append(cmd.Env, env...)
 
-	err = cmd.Start()
+	cg, err := e.applyLimits(cmd)
+	if err != nil {
+		return err
+	}
+	if cg != nil {
+		defer func() {
+			if err := cg.remove(); err != nil {
+				logrus.Error(err)
+			}
+		}()
+	}
+
+	reaper := getReaper()
+	err = reaper.start(cmd)
 	if err != nil {
 		return err
 	}
@@ -37,26 +61,9 @@ func (e *Exec) RunWithEnv(env []string, head string, parts ...string) (err error
 	logrus.Debugf("started pid %d, %s", cmd.Process.Pid, head+" "+strings.Join(parts, " "))
 
 	err = cmd.Wait()
-
-	e.reapChildren()
-	return err
-}
-
-func (e *Exec) reapChildren() {
-	for {
-		var wstatus syscall.WaitStatus
-		pid, err := syscall.Wait4(-1, &wstatus, syscall.WNOHANG, nil)
-		if errors.Is(err, syscall.ECHILD) {
-			return
-		}
-		if err != nil {
-			logrus.Errorf("error waiting for child %d: %s", pid, err)
-			continue
-		}
-		if pid == 0 && wstatus == 0 {
-			logrus.Debug("found no pid")
-			return
-		}
-		logrus.Debugf("reaped zombie %d %d", pid, wstatus)
+	reaper.done(cmd.Process.Pid)
+	if err != nil && cg != nil && cg.oomKilled() {
+		err = fmt.Errorf("%w: %w", ErrOOMKilled, err)
 	}
+	return err
 }
@@ -0,0 +1,81 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const bitbucketPageLimit = 100
+
+// Bitbucket discovers repos using the Bitbucket Server REST API.
+type Bitbucket struct {
+	// Endpoint is the same as RENOVATE_ENDPOINT, ex https://bitbucket.example.com/
+	Endpoint string
+	Token    string
+	// Projects limits discovery to the given project keys. All repos the token can see are discovered if empty.
+	Projects        []string
+	IncludeArchived bool
+	Client          *http.Client
+}
+
+type bitbucketPage struct {
+	IsLastPage    bool                  `json:"isLastPage"`
+	NextPageStart int                   `json:"nextPageStart"`
+	Values        []bitbucketRepository `json:"values"`
+}
+
+type bitbucketRepository struct {
+	Slug     string `json:"slug"`
+	Archived bool   `json:"archived"`
+	Project  struct {
+		Key string `json:"key"`
+	} `json:"project"`
+}
+
+func (b *Bitbucket) Discover(ctx context.Context) ([]string, error) {
+	if len(b.Projects) == 0 {
+		return b.discover(ctx, "/rest/api/1.0/repos")
+	}
+
+	repos := []string{}
+	for _, project := range b.Projects {
+		r, err := b.discover(ctx, "/rest/api/1.0/projects/"+url.PathEscape(project)+"/repos")
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, r...)
+	}
+	return repos, nil
+}
+
+func (b *Bitbucket) discover(ctx context.Context, path string) ([]string, error) {
+	base := strings.TrimSuffix(strings.TrimSuffix(b.Endpoint, "/"), "/rest/api/1.0")
+	headers := map[string]string{"Authorization": "Bearer " + b.Token}
+
+	repos := []string{}
+	start := 0
+	for {
+		u := base + path + "?limit=" + strconv.Itoa(bitbucketPageLimit) + "&start=" + strconv.Itoa(start)
+		page := &bitbucketPage{}
+		_, err := getJSON(ctx, b.Client, u, headers, page)
+		if err != nil {
+			return nil, fmt.Errorf("error listing bitbucket repos: %w", err)
+		}
+
+		for _, repo := range page.Values {
+			if repo.Archived && !b.IncludeArchived {
+				continue
+			}
+			repos = append(repos, repo.Project.Key+"/"+repo.Slug)
+		}
+
+		if page.IsLastPage || len(page.Values) == 0 {
+			return repos, nil
+		}
+		start = page.NextPageStart
+	}
+}
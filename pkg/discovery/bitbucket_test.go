@@ -0,0 +1,71 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBitbucketDiscover(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/rest/api/1.0/repos", r.URL.Path)
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		assert.Equal(t, "100", r.URL.Query().Get("limit"))
+
+		var page string
+		switch r.URL.Query().Get("start") {
+		case "0":
+			page = `{"isLastPage":false,"nextPageStart":2,"values":[
+				{"slug":"repo1","project":{"key":"PROJ1"}},
+				{"slug":"repo2","archived":true,"project":{"key":"PROJ1"}}]}`
+		case "2":
+			page = `{"isLastPage":true,"values":[{"slug":"repo1","project":{"key":"PROJ2"}}]}`
+		default:
+			t.Errorf("unexpected start: %s", r.URL.Query().Get("start"))
+		}
+		_, _ = w.Write([]byte(page))
+	}))
+	defer ts.Close()
+
+	b := &Bitbucket{Endpoint: ts.URL + "/rest/api/1.0/", Token: "secret"}
+	repos, err := b.Discover(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"PROJ1/repo1", "PROJ2/repo1"}, repos)
+
+	b.IncludeArchived = true
+	repos, err = b.Discover(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"PROJ1/repo1", "PROJ1/repo2", "PROJ2/repo1"}, repos)
+}
+
+func TestBitbucketDiscoverProjects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rest/api/1.0/projects/{project}/repos", func(w http.ResponseWriter, r *http.Request) {
+		repo := bitbucketRepository{Slug: "repo1"}
+		repo.Project.Key = r.PathValue("project")
+		err := json.NewEncoder(w).Encode(bitbucketPage{IsLastPage: true, Values: []bitbucketRepository{repo}})
+		assert.NoError(t, err)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	b := &Bitbucket{Endpoint: ts.URL, Projects: []string{"PROJ1", "PROJ2"}}
+	repos, err := b.Discover(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"PROJ1/repo1", "PROJ2/repo1"}, repos)
+}
+
+func TestBitbucketDiscoverError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	b := &Bitbucket{Endpoint: ts.URL}
+	_, err := b.Discover(context.Background())
+	assert.ErrorContains(t, err, "unexpected status code")
+}
@@ -0,0 +1,63 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Discoverer returns the list of repos that renovate should run on.
+type Discoverer interface {
+	Discover(ctx context.Context) ([]string, error)
+}
+
+// hasAnyTopic returns true if want is empty or if any of the topics is wanted.
+func hasAnyTopic(topics []string, want []string) bool {
+	if len(want) == 0 {
+		return true
+	}
+	for _, w := range want {
+		for _, t := range topics {
+			if strings.EqualFold(w, t) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getJSON does an authenticated GET request and decodes the json response into out.
+func getJSON(ctx context.Context, client *http.Client, u string, headers map[string]string, out interface{}) (http.Header, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("unexpected status code from %s: %d body: %s", u, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(out)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding response from %s: %w", u, err)
+	}
+	return resp.Header, nil
+}
@@ -0,0 +1,118 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const defaultGitHubEndpoint = "https://api.github.com"
+
+// GitHub discovers repos using the GitHub REST API.
+type GitHub struct {
+	// Endpoint is the same as RENOVATE_ENDPOINT, defaults to https://api.github.com
+	Endpoint string
+	Token    string
+	// Orgs limits discovery to the given organizations. If empty the repos of the
+	// installation (for app tokens) or the authenticated user are discovered.
+	Orgs []string
+	// Topics only discovers repos that has at least one of the topics.
+	Topics          []string
+	IncludeArchived bool
+	Client          *http.Client
+}
+
+type githubRepository struct {
+	FullName string   `json:"full_name"`
+	Archived bool     `json:"archived"`
+	Topics   []string `json:"topics"`
+}
+
+type githubInstallationRepositories struct {
+	Repositories []githubRepository `json:"repositories"`
+}
+
+func (g *GitHub) Discover(ctx context.Context) ([]string, error) {
+	base := strings.TrimSuffix(g.Endpoint, "/")
+	if base == "" {
+		base = defaultGitHubEndpoint
+	}
+
+	var paths []string
+	switch {
+	case len(g.Orgs) > 0:
+		for _, org := range g.Orgs {
+			paths = append(paths, "/orgs/"+url.PathEscape(org)+"/repos")
+		}
+	case strings.HasPrefix(g.Token, "ghs_"): // installation tokens from a github app
+		paths = append(paths, "/installation/repositories")
+	default:
+		paths = append(paths, "/user/repos")
+	}
+
+	repos := []string{}
+	for _, path := range paths {
+		r, err := g.discover(ctx, base+path+"?per_page=100")
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, r...)
+	}
+	return repos, nil
+}
+
+func (g *GitHub) discover(ctx context.Context, u string) ([]string, error) {
+	headers := map[string]string{
+		"Authorization":        "Bearer " + g.Token,
+		"Accept":               "application/vnd.github+json",
+		"X-GitHub-Api-Version": "2022-11-28",
+	}
+
+	repos := []string{}
+	for u != "" {
+		var page []githubRepository
+		var header http.Header
+		var err error
+		if strings.Contains(u, "/installation/repositories") {
+			installationPage := &githubInstallationRepositories{}
+			header, err = getJSON(ctx, g.Client, u, headers, installationPage)
+			page = installationPage.Repositories
+		} else {
+			header, err = getJSON(ctx, g.Client, u, headers, &page)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error listing github repos: %w", err)
+		}
+
+		for _, repo := range page {
+			if repo.Archived && !g.IncludeArchived {
+				continue
+			}
+			if !hasAnyTopic(repo.Topics, g.Topics) {
+				continue
+			}
+			repos = append(repos, repo.FullName)
+		}
+
+		u = nextLink(header.Get("Link"))
+	}
+	return repos, nil
+}
+
+// nextLink returns the url with rel="next" from a Link header.
+func nextLink(link string) string {
+	for _, part := range strings.Split(link, ",") {
+		u, params, ok := strings.Cut(strings.TrimSpace(part), ";")
+		if !ok {
+			continue
+		}
+		for _, param := range strings.Split(params, ";") {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return strings.Trim(strings.TrimSpace(u), "<>")
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,57 @@
+package discovery
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGitHubDiscoverOrgs(t *testing.T) {
+	var ts *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/orgs/org1/repos", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		if r.URL.Query().Get("page") == "" {
+			w.Header().Set("Link", `<`+ts.URL+`/orgs/org1/repos?per_page=100&page=2>; rel="next", <`+ts.URL+`/orgs/org1/repos?per_page=100&page=2>; rel="last"`)
+			_, _ = w.Write([]byte(`[{"full_name":"org1/repo1","topics":["renovate"]},{"full_name":"org1/repo2","archived":true,"topics":["renovate"]}]`))
+			return
+		}
+		_, _ = w.Write([]byte(`[{"full_name":"org1/repo3"}]`))
+	})
+	ts = httptest.NewServer(mux)
+	defer ts.Close()
+
+	g := &GitHub{Endpoint: ts.URL + "/", Token: "secret", Orgs: []string{"org1"}}
+	repos, err := g.Discover(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"org1/repo1", "org1/repo3"}, repos)
+
+	g.Topics = []string{"renovate"}
+	g.IncludeArchived = true
+	repos, err = g.Discover(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"org1/repo1", "org1/repo2"}, repos)
+}
+
+func TestGitHubDiscoverInstallation(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/installation/repositories", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"total_count":1,"repositories":[{"full_name":"org1/repo1"}]}`))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	g := &GitHub{Endpoint: ts.URL, Token: "ghs_installationtoken"}
+	repos, err := g.Discover(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"org1/repo1"}, repos)
+}
+
+func TestNextLink(t *testing.T) {
+	assert.Equal(t, "https://api.github.com/user/repos?page=3", nextLink(`<https://api.github.com/user/repos?page=1>; rel="prev", <https://api.github.com/user/repos?page=3>; rel="next"`))
+	assert.Equal(t, "", nextLink(`<https://api.github.com/user/repos?page=1>; rel="prev"`))
+	assert.Equal(t, "", nextLink(""))
+}
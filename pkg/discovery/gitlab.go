@@ -0,0 +1,96 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const defaultGitLabEndpoint = "https://gitlab.com/api/v4"
+
+// GitLab discovers repos using the GitLab REST API.
+type GitLab struct {
+	// Endpoint is the same as RENOVATE_ENDPOINT, defaults to https://gitlab.com/api/v4
+	Endpoint string
+	Token    string
+	// Groups limits discovery to the given groups including subgroups. If empty all
+	// projects the token is a member of are discovered.
+	Groups []string
+	// Topics only discovers repos that has at least one of the topics.
+	Topics          []string
+	IncludeArchived bool
+	Client          *http.Client
+}
+
+type gitlabProject struct {
+	PathWithNamespace string   `json:"path_with_namespace"`
+	Archived          bool     `json:"archived"`
+	Topics            []string `json:"topics"`
+}
+
+func (g *GitLab) Discover(ctx context.Context) ([]string, error) {
+	base := strings.TrimSuffix(g.Endpoint, "/")
+	if base == "" {
+		base = defaultGitLabEndpoint
+	}
+	if !strings.HasSuffix(base, "/api/v4") {
+		base += "/api/v4"
+	}
+
+	query := url.Values{}
+	query.Set("per_page", "100")
+	if !g.IncludeArchived {
+		query.Set("archived", "false")
+	}
+
+	paths := []string{"/projects"}
+	query.Set("membership", "true")
+	if len(g.Groups) > 0 {
+		paths = nil
+		for _, group := range g.Groups {
+			paths = append(paths, "/groups/"+url.PathEscape(group)+"/projects")
+		}
+		query.Del("membership")
+		query.Set("include_subgroups", "true")
+	}
+
+	repos := []string{}
+	for _, path := range paths {
+		r, err := g.discover(ctx, base+path, query)
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, r...)
+	}
+	return repos, nil
+}
+
+func (g *GitLab) discover(ctx context.Context, u string, query url.Values) ([]string, error) {
+	headers := map[string]string{"Authorization": "Bearer " + g.Token}
+
+	repos := []string{}
+	page := "1"
+	for page != "" {
+		query.Set("page", page)
+		var projects []gitlabProject
+		header, err := getJSON(ctx, g.Client, u+"?"+query.Encode(), headers, &projects)
+		if err != nil {
+			return nil, fmt.Errorf("error listing gitlab projects: %w", err)
+		}
+
+		for _, project := range projects {
+			if project.Archived && !g.IncludeArchived {
+				continue
+			}
+			if !hasAnyTopic(project.Topics, g.Topics) {
+				continue
+			}
+			repos = append(repos, project.PathWithNamespace)
+		}
+
+		page = header.Get("X-Next-Page")
+	}
+	return repos, nil
+}
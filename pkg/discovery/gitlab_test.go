@@ -0,0 +1,53 @@
+package discovery
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGitLabDiscoverGroups(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v4/groups/{group}/projects", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "group1/sub", r.PathValue("group"))
+		assert.Equal(t, "true", r.URL.Query().Get("include_subgroups"))
+		assert.Equal(t, "false", r.URL.Query().Get("archived"))
+		if r.URL.Query().Get("page") == "1" {
+			w.Header().Set("X-Next-Page", "2")
+			_, _ = w.Write([]byte(`[{"path_with_namespace":"group1/sub/repo1","topics":["java"]}]`))
+			return
+		}
+		_, _ = w.Write([]byte(`[{"path_with_namespace":"group1/sub/repo2","topics":["go"]}]`))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	g := &GitLab{Endpoint: ts.URL, Groups: []string{"group1/sub"}}
+	repos, err := g.Discover(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"group1/sub/repo1", "group1/sub/repo2"}, repos)
+
+	g.Topics = []string{"go"}
+	repos, err = g.Discover(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"group1/sub/repo2"}, repos)
+}
+
+func TestGitLabDiscoverMembership(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v4/projects", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "true", r.URL.Query().Get("membership"))
+		assert.Equal(t, "", r.URL.Query().Get("archived"))
+		_, _ = w.Write([]byte(`[{"path_with_namespace":"group1/repo1","archived":true}]`))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	g := &GitLab{Endpoint: ts.URL + "/api/v4/", IncludeArchived: true}
+	repos, err := g.Discover(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"group1/repo1"}, repos)
+}
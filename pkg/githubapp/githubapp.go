@@ -0,0 +1,214 @@
+package githubapp
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+const defaultEndpoint = "https://api.github.com"
+
+// refreshBefore is how long before expiry a cached installation token is replaced. Installation tokens are valid for an
+// hour so a run started with a cached token has at least this long to finish.
+const refreshBefore = 15 * time.Minute
+
+// App mints installation tokens of a GitHub App, tokens are cached per owner until they are about to expire.
+type App struct {
+	ID         int64
+	PrivateKey *rsa.PrivateKey
+	// Endpoint is the same as RENOVATE_ENDPOINT, defaults to https://api.github.com
+	Endpoint string
+	Client   *http.Client
+
+	mu     sync.Mutex
+	tokens map[string]token
+	now    func() time.Time
+}
+
+type token struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type installation struct {
+	ID int64 `json:"id"`
+}
+
+// NewFromContext returns nil if no GitHub App is configured.
+func NewFromContext(cCtx *cli.Context) (*App, error) {
+	id := cCtx.Int64("github-app-id")
+	if id == 0 {
+		return nil, nil
+	}
+	b, err := os.ReadFile(cCtx.String("github-app-private-key"))
+	if err != nil {
+		return nil, fmt.Errorf("error reading github app private key, err: %w", err)
+	}
+	key, err := ParsePrivateKey(b)
+	if err != nil {
+		return nil, err
+	}
+	return &App{ID: id, PrivateKey: key, Endpoint: cCtx.String("github-app-endpoint")}, nil
+}
+
+// ParsePrivateKey parses a PEM encoded PKCS1 or PKCS8 RSA key as downloaded from the settings of the app.
+func ParsePrivateKey(b []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("github app private key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing github app private key, err: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("github app private key is not a RSA key")
+	}
+	return rsaKey, nil
+}
+
+// Serves returns true if jobs on endpoint are on the GitHub instance of the app, an empty endpoint is the default.
+func (a *App) Serves(endpoint string) bool {
+	return endpoint == "" || strings.TrimSuffix(endpoint, "/") == a.base()
+}
+
+// Token returns an installation token for the installation of the app on the owner of repo.
+func (a *App) Token(ctx context.Context, repo string) (string, error) {
+	owner, _, _ := strings.Cut(repo, "/")
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.tokens == nil {
+		a.tokens = make(map[string]token)
+	}
+	if t, ok := a.tokens[owner]; ok && a.clock().Add(refreshBefore).Before(t.ExpiresAt) {
+		return t.Token, nil
+	}
+
+	jwt, err := a.jwt()
+	if err != nil {
+		return "", err
+	}
+	inst, err := a.installation(ctx, jwt, owner)
+	if err != nil {
+		return "", err
+	}
+
+	t := token{}
+	err = a.do(ctx, http.MethodPost, fmt.Sprintf("/app/installations/%d/access_tokens", inst.ID), jwt, &t)
+	if err != nil {
+		return "", fmt.Errorf("error creating installation token for %s, err: %w", owner, err)
+	}
+	a.tokens[owner] = t
+	return t.Token, nil
+}
+
+// installation looks up the installation of the app on an organization and falls back to a user.
+func (a *App) installation(ctx context.Context, jwt string, owner string) (*installation, error) {
+	inst := &installation{}
+	err := a.do(ctx, http.MethodGet, "/orgs/"+url.PathEscape(owner)+"/installation", jwt, inst)
+	if errors.Is(err, errNotFound) {
+		err = a.do(ctx, http.MethodGet, "/users/"+url.PathEscape(owner)+"/installation", jwt, inst)
+	}
+	if errors.Is(err, errNotFound) {
+		return nil, fmt.Errorf("github app %d is not installed on %s", a.ID, owner)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error looking up installation on %s, err: %w", owner, err)
+	}
+	return inst, nil
+}
+
+// jwt returns a token authenticating as the app, it is valid for 10 minutes.
+func (a *App) jwt() (string, error) {
+	now := a.clock()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		// issued in the past to allow for clock drift
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": strconv.FormatInt(a.ID, 10),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.PrivateKey, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", fmt.Errorf("error signing github app jwt, err: %w", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+var errNotFound = errors.New("not found")
+
+func (a *App) do(ctx context.Context, method string, path string, jwt string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, a.base()+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+
+	client := a.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("unexpected status code from %s: %d body: %s", path, resp.StatusCode, bytes.TrimSpace(body[:min(len(body), 1024)]))
+	}
+	return json.Unmarshal(body, out)
+}
+
+func (a *App) base() string {
+	if a.Endpoint == "" {
+		return defaultEndpoint
+	}
+	return strings.TrimSuffix(a.Endpoint, "/")
+}
+
+func (a *App) clock() time.Time {
+	if a.now != nil {
+		return a.now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,116 @@
+package githubapp
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	minted := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /orgs/org1/installation", func(w http.ResponseWriter, r *http.Request) {
+		assertJWT(t, &key.PublicKey, r, now)
+		_, _ = w.Write([]byte(`{"id":11}`))
+	})
+	mux.HandleFunc("GET /orgs/user1/installation", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("GET /users/user1/installation", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"id":22}`))
+	})
+	mux.HandleFunc("POST /app/installations/{id}/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		assertJWT(t, &key.PublicKey, r, now)
+		minted++
+		w.WriteHeader(http.StatusCreated)
+		_, _ = fmt.Fprintf(w, `{"token":"ghs_%s_%d","expires_at":"%s"}`, r.PathValue("id"), minted, now.Add(time.Hour).Format(time.RFC3339))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	app := &App{ID: 123, PrivateKey: key, Endpoint: ts.URL, now: func() time.Time { return now }}
+	ctx := context.Background()
+
+	token, err := app.Token(ctx, "org1/repo1")
+	assert.NoError(t, err)
+	assert.Equal(t, "ghs_11_1", token)
+
+	// cached per owner
+	token, err = app.Token(ctx, "org1/repo2")
+	assert.NoError(t, err)
+	assert.Equal(t, "ghs_11_1", token)
+
+	token, err = app.Token(ctx, "user1/repo1")
+	assert.NoError(t, err)
+	assert.Equal(t, "ghs_22_2", token)
+
+	// replaced when it is about to expire
+	now = now.Add(50 * time.Minute)
+	token, err = app.Token(ctx, "org1/repo1")
+	assert.NoError(t, err)
+	assert.Equal(t, "ghs_11_3", token)
+
+	_, err = app.Token(ctx, "other/repo1")
+	assert.ErrorContains(t, err, "not installed on other")
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	pkcs1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	parsed, err := ParsePrivateKey(pkcs1)
+	assert.NoError(t, err)
+	assert.True(t, key.Equal(parsed))
+
+	b, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(t, err)
+	parsed, err = ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: b}))
+	assert.NoError(t, err)
+	assert.True(t, key.Equal(parsed))
+
+	_, err = ParsePrivateKey([]byte("not a key"))
+	assert.Error(t, err)
+}
+
+func assertJWT(t *testing.T, key *rsa.PublicKey, r *http.Request, now time.Time) {
+	t.Helper()
+	parts := strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), ".")
+	if !assert.Len(t, parts, 3) {
+		return
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	assert.NoError(t, err)
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	assert.NoError(t, rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature))
+
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	assert.NoError(t, err)
+	claims := struct {
+		Iss string `json:"iss"`
+		Iat int64  `json:"iat"`
+		Exp int64  `json:"exp"`
+	}{}
+	assert.NoError(t, json.Unmarshal(b, &claims))
+	assert.Equal(t, "123", claims.Iss)
+	assert.Less(t, claims.Iat, now.Unix())
+	assert.LessOrEqual(t, claims.Exp-claims.Iat, int64(10*60))
+}
@@ -0,0 +1,144 @@
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fortnoxab/renovator/pkg/overrides"
+	"github.com/fortnoxab/renovator/pkg/renovate"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	runKeyPrefix     = "renovator-run:"
+	historyKeyPrefix = "renovator-history:"
+
+	// Length is the number of runs kept in the history of each repo.
+	Length = 100
+	// TTL is how long a run is kept in redis.
+	TTL = 30 * 24 * time.Hour
+)
+
+const (
+	StatusRunning = "running"
+	StatusOK      = "ok"
+	StatusError   = "error"
+	// StatusOOM is a run killed by the kernel for using more memory than its limit.
+	StatusOOM = "oom"
+)
+
+var ErrNotFound = errors.New("run not found")
+
+// Run is a record of a renovate run on a repo.
+type Run struct {
+	ID    string `json:"id"`
+	Job   string `json:"job"`
+	Repo  string `json:"repo"`
+	Agent string `json:"agent"`
+	// AgentURL is where the agent running the job can be reached.
+	AgentURL string    `json:"agentUrl,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished,omitempty"`
+	// Status is running, ok, error or oom
+	Status string              `json:"status,omitempty"`
+	Error  string              `json:"error,omitempty"`
+	Result *renovate.RunResult `json:"result,omitempty"`
+	// Version is the renovate version the run was started with.
+	Version string `json:"version,omitempty"`
+	// Overrides is the extra environment and arguments the run was started with.
+	Overrides *overrides.Override `json:"overrides,omitempty"`
+}
+
+// Target returns the target of the job of the run, see renovate.Job.Target. The history is kept per target so repos with
+// the same name on different platforms have their own history.
+func (r *Run) Target() string {
+	job, err := renovate.ParseJob(r.Job)
+	if err != nil {
+		return r.Repo
+	}
+	return job.Target()
+}
+
+func runKey(id string) string {
+	return runKeyPrefix + id
+}
+
+func historyKey(target string) string {
+	return historyKeyPrefix + target
+}
+
+// Start stores the run and adds it to the history of its target.
+func Start(ctx context.Context, redisClient redis.Cmdable, run *Run) error {
+	err := Save(ctx, redisClient, run)
+	if err != nil {
+		return err
+	}
+
+	target := run.Target()
+	err = redisClient.LPush(ctx, historyKey(target), run.ID).Err()
+	if err != nil {
+		return fmt.Errorf("error adding run %s to history: %w", run.ID, err)
+	}
+	err = redisClient.LTrim(ctx, historyKey(target), 0, Length-1).Err()
+	if err != nil {
+		return fmt.Errorf("error trimming history of %s: %w", target, err)
+	}
+	err = redisClient.Expire(ctx, historyKey(target), TTL).Err()
+	if err != nil {
+		return fmt.Errorf("error setting ttl on history of %s: %w", target, err)
+	}
+	return nil
+}
+
+// Save stores the current state of the run.
+func Save(ctx context.Context, redisClient redis.Cmdable, run *Run) error {
+	b, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	err = redisClient.Set(ctx, runKey(run.ID), b, TTL).Err()
+	if err != nil {
+		return fmt.Errorf("error saving run %s: %w", run.ID, err)
+	}
+	return nil
+}
+
+// Get returns ErrNotFound if the run does not exist.
+func Get(ctx context.Context, redisClient redis.Cmdable, id string) (*Run, error) {
+	b, err := redisClient.Get(ctx, runKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting run %s: %w", id, err)
+	}
+
+	run := &Run{}
+	err = json.Unmarshal(b, run)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding run %s: %w", id, err)
+	}
+	return run, nil
+}
+
+// Purge removes the history of a target and all its runs, see Run.Target.
+func Purge(ctx context.Context, redisClient redis.Cmdable, target string) error {
+	ids, err := redisClient.LRange(ctx, historyKey(target), 0, -1).Result()
+	if err != nil && err != redis.Nil {
+		return fmt.Errorf("error getting history of %s: %w", target, err)
+	}
+
+	keys := []string{historyKey(target)}
+	for _, id := range ids {
+		keys = append(keys, runKey(id))
+	}
+	err = redisClient.Del(ctx, keys...).Err()
+	if err != nil {
+		return fmt.Errorf("error deleting history of %s: %w", target, err)
+	}
+	return nil
+}
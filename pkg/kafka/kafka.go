@@ -7,18 +7,20 @@ import (
 	"sync"
 
 	"github.com/IBM/sarama"
+	"github.com/fortnoxab/renovator/pkg/platform"
 	localredis "github.com/fortnoxab/renovator/pkg/redis"
 	"github.com/jonaz/mgit/pkg/bitbucket"
 	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
 )
 
-func Start(ctx context.Context, brokers string, redisClient redis.Cmdable) {
+// Start consumes bitbucket webhooks. Jobs are queued for p, or for the platform of the agents if it is empty.
+func Start(ctx context.Context, brokers string, redisClient redis.Cmdable, router localredis.Router, p platform.Platform) {
 	config := sarama.NewConfig()
 	config.Version = sarama.V3_5_1_0
 	group := "renovator-master"
 
-	consumer := Consumer{redis: redisClient}
+	consumer := Consumer{redis: redisClient, router: router, platform: p}
 	client, err := sarama.NewConsumerGroup(strings.Split(brokers, ","), group, config)
 	if err != nil {
 		logrus.Errorf("Error creating consumer group client: %v", err)
@@ -53,7 +55,9 @@ func Start(ctx context.Context, brokers string, redisClient redis.Cmdable) {
 
 // Consumer represents a Sarama consumer group consumer
 type Consumer struct {
-	redis redis.Cmdable
+	redis    redis.Cmdable
+	router   localredis.Router
+	platform platform.Platform
 }
 
 // Setup is run at the beginning of a new session, before ConsumeClaim
@@ -95,18 +99,21 @@ func (consumer *Consumer) ConsumeClaim(session sarama.ConsumerGroupSession, clai
 			hook := hookData.HookData
 			if strings.HasPrefix(hook.PullRequest.Title, "rebase!") && hook.PullRequest.Title != hook.PreviousTitle {
 				repo := hook.PullRequest.ToRef.Repository.Project.Key + "/" + hook.PullRequest.ToRef.Repository.Slug
-
-				// If its a webhook and its already in the queue to be processed we move it first in the queue.
-				err = consumer.redis.LRem(session.Context(), localredis.RedisRepoListKey, 0, repo).Err()
+				router, err := consumer.router.WithAgents(session.Context(), consumer.redis)
 				if err != nil {
-					logrus.Errorf("error LRem: %s", err)
+					logrus.Errorf("error listing agents: %s", err)
 					continue
 				}
+				job := router.Job(repo)
+				job.Platform = consumer.platform.Platform
+				job.Endpoint = consumer.platform.Endpoint
+				queue := router.QueueKey(job)
 
+				// If its a webhook and its already in the queue to be processed we move it first in the queue.
 				logrus.Infof("trigger renovate on %s due to 'rebase!' in PR %s", repo, hook.PullRequest.Links.Self[0].Href)
-				err = consumer.redis.LPush(session.Context(), localredis.RedisRepoListKey, []string{repo}).Err()
+				err = localredis.MoveFirst(session.Context(), consumer.redis, queue, job.String())
 				if err != nil {
-					logrus.Errorf("error LPush: %s", err)
+					logrus.Error(err)
 				}
 			}
 
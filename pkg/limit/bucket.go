@@ -0,0 +1,54 @@
+package limit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// takeScript refills the bucket for the time since the last take and takes a token if there is one.
+// It returns the number of milliseconds until a token is available, 0 if one was taken.
+var takeScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+else
+	wait = math.ceil((1 - tokens) / rate)
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate))
+return wait
+`)
+
+// TokenBucket limits the rate of events across all agents. It is refilled with Rate tokens per minute
+// and holds at most Burst tokens, Rate if Burst is not set.
+type TokenBucket struct {
+	Key   string
+	Rate  int
+	Burst int
+}
+
+// Take takes a token. If the bucket is empty it returns how long until a token is available.
+func (b TokenBucket) Take(ctx context.Context, redisClient redis.Cmdable) (time.Duration, error) {
+	burst := b.Burst
+	if burst <= 0 {
+		burst = b.Rate
+	}
+	perMs := float64(b.Rate) / float64(time.Minute.Milliseconds())
+	wait, err := takeScript.Run(ctx, redisClient, []string{b.Key},
+		strconv.FormatFloat(perMs, 'g', -1, 64), burst, time.Now().UnixMilli()).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("error taking token from %s, err: %w", b.Key, err)
+	}
+	return time.Duration(wait) * time.Millisecond, nil
+}
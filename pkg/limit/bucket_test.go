@@ -0,0 +1,32 @@
+package limit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fortnoxab/renovator/mocks"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestTokenBucket(t *testing.T) {
+	redisMock := mocks.NewMockCmdable(t)
+	b := TokenBucket{Key: "renovator-starts", Rate: 60}
+
+	redisMock.On("EvalSha", mock.Anything, mock.Anything, []string{b.Key}, "0.001", 60, mock.Anything).
+		Return(redis.NewCmdResult(int64(0), nil)).
+		Once()
+	redisMock.On("EvalSha", mock.Anything, mock.Anything, []string{b.Key}, "0.001", 60, mock.Anything).
+		Return(redis.NewCmdResult(int64(400), nil)).
+		Once()
+
+	wait, err := b.Take(context.Background(), redisMock)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), wait)
+
+	wait, err = b.Take(context.Background(), redisMock)
+	assert.NoError(t, err)
+	assert.Equal(t, 400*time.Millisecond, wait)
+}
@@ -0,0 +1,105 @@
+package limit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrLeaseLost is returned when refreshing a lease that has expired and been removed.
+var ErrLeaseLost = errors.New("lease lost")
+
+// acquireScript removes expired leases and adds a new one if there are less than the limit left.
+var acquireScript = redis.NewScript(`
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", ARGV[1])
+if redis.call("ZCARD", KEYS[1]) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call("ZADD", KEYS[1], ARGV[3], ARGV[4])
+redis.call("PEXPIRE", KEYS[1], ARGV[5])
+return 1
+`)
+
+// Semaphore limits the number of concurrent holders across all agents. Every holder has a lease in a
+// sorted set scored by when it expires so leases of crashed agents are freed after TTL.
+type Semaphore struct {
+	Key   string
+	Limit int
+	TTL   time.Duration
+}
+
+// Acquire takes a lease with id. It returns nil if the semaphore is full.
+func (s Semaphore) Acquire(ctx context.Context, redisClient redis.Cmdable, id string) (*Lease, error) {
+	now := time.Now()
+	ok, err := acquireScript.Run(ctx, redisClient, []string{s.Key},
+		now.UnixMilli(), s.Limit, now.Add(s.TTL).UnixMilli(), id, s.TTL.Milliseconds()).Int()
+	if err != nil {
+		return nil, fmt.Errorf("error acquiring %s, err: %w", s.Key, err)
+	}
+	if ok == 0 {
+		return nil, nil
+	}
+	return &Lease{key: s.Key, id: id, ttl: s.TTL, redisClient: redisClient}, nil
+}
+
+// Lease is a held slot of a Semaphore.
+type Lease struct {
+	key         string
+	id          string
+	ttl         time.Duration
+	redisClient redis.Cmdable
+}
+
+// Refresh extends the lease by the TTL of the semaphore.
+func (l *Lease) Refresh(ctx context.Context) error {
+	changed, err := l.redisClient.ZAddArgs(ctx, l.key, redis.ZAddArgs{
+		XX:      true,
+		Ch:      true,
+		Members: []redis.Z{{Score: float64(time.Now().Add(l.ttl).UnixMilli()), Member: l.id}},
+	}).Result()
+	if err != nil {
+		return fmt.Errorf("error refreshing lease on %s, err: %w", l.key, err)
+	}
+	if changed == 0 {
+		return fmt.Errorf("lease %s on %s, err: %w", l.id, l.key, ErrLeaseLost)
+	}
+	return l.redisClient.PExpire(ctx, l.key, l.ttl).Err()
+}
+
+// Release frees the slot.
+func (l *Lease) Release(ctx context.Context) error {
+	err := l.redisClient.ZRem(ctx, l.key, l.id).Err()
+	if err != nil {
+		return fmt.Errorf("error releasing lease on %s, err: %w", l.key, err)
+	}
+	return nil
+}
+
+// KeepAlive refreshes leases every third of their TTL until ctx is cancelled.
+func KeepAlive(ctx context.Context, leases []*Lease, onError func(error)) {
+	if len(leases) == 0 {
+		return
+	}
+	interval := leases[0].ttl
+	for _, l := range leases {
+		interval = min(interval, l.ttl)
+	}
+
+	ticker := time.NewTicker(interval / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, l := range leases {
+			if err := l.Refresh(ctx); err != nil && ctx.Err() == nil {
+				onError(err)
+			}
+		}
+	}
+}
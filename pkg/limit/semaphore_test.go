@@ -0,0 +1,49 @@
+package limit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fortnoxab/renovator/mocks"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSemaphore(t *testing.T) {
+	redisMock := mocks.NewMockCmdable(t)
+	s := Semaphore{Key: "renovator-running:project=project1", Limit: 2, TTL: time.Minute}
+
+	redisMock.On("EvalSha", mock.Anything, mock.Anything, []string{s.Key}, mock.Anything, 2, mock.Anything, "run1", int64(60000)).
+		Return(redis.NewCmdResult(int64(1), nil)).
+		Once()
+	redisMock.On("EvalSha", mock.Anything, mock.Anything, []string{s.Key}, mock.Anything, 2, mock.Anything, "run2", int64(60000)).
+		Return(redis.NewCmdResult(int64(0), nil)).
+		Once()
+	redisMock.On("ZAddArgs", mock.Anything, s.Key, mock.MatchedBy(func(args redis.ZAddArgs) bool { return args.XX && args.Members[0].Member == "run1" })).
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+	redisMock.On("ZAddArgs", mock.Anything, s.Key, mock.MatchedBy(func(args redis.ZAddArgs) bool { return args.XX && args.Members[0].Member == "run1" })).
+		Return(redis.NewIntResult(0, nil)).
+		Once()
+	redisMock.On("PExpire", mock.Anything, s.Key, time.Minute).
+		Return(redis.NewBoolResult(true, nil)).
+		Once()
+	redisMock.On("ZRem", mock.Anything, s.Key, "run1").
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+
+	ctx := context.Background()
+	lease, err := s.Acquire(ctx, redisMock, "run1")
+	assert.NoError(t, err)
+	assert.NotNil(t, lease)
+
+	full, err := s.Acquire(ctx, redisMock, "run2")
+	assert.NoError(t, err)
+	assert.Nil(t, full)
+
+	assert.NoError(t, lease.Refresh(ctx))
+	assert.ErrorIs(t, lease.Refresh(ctx), ErrLeaseLost)
+	assert.NoError(t, lease.Release(ctx))
+}
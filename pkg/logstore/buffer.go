@@ -0,0 +1,51 @@
+package logstore
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+)
+
+// TailBuffer keeps the last size bytes written to it.
+type TailBuffer struct {
+	mu      sync.Mutex
+	size    int
+	buf     []byte
+	dropped int64
+}
+
+func NewTailBuffer(size int) *TailBuffer {
+	return &TailBuffer{size: size}
+}
+
+func (b *TailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.buf = append(b.buf, p...)
+	if over := len(b.buf) - b.size; over > 0 {
+		b.dropped += int64(over)
+		b.buf = b.buf[over:]
+	}
+	return len(p), nil
+}
+
+// Bytes returns a copy of the buffer. If data has been dropped the first partial line is
+// removed as well and a truncation marker is added at the start.
+func (b *TailBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.dropped == 0 {
+		return bytes.Clone(b.buf)
+	}
+
+	data := b.buf
+	dropped := b.dropped
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		dropped += int64(i + 1)
+		data = data[i+1:]
+	}
+	marker := fmt.Sprintf("[renovator: log truncated, %d bytes omitted]\n", dropped)
+	return append([]byte(marker), data...)
+}
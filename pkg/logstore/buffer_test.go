@@ -0,0 +1,16 @@
+package logstore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTailBuffer(t *testing.T) {
+	b := NewTailBuffer(16)
+	_, _ = b.Write([]byte("line1\nline2\n"))
+	assert.Equal(t, "line1\nline2\n", string(b.Bytes()))
+
+	_, _ = b.Write([]byte("line3\nline4\n"))
+	assert.Equal(t, "[renovator: log truncated, 12 bytes omitted]\nline3\nline4\n", string(b.Bytes()))
+}
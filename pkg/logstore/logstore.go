@@ -0,0 +1,75 @@
+package logstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fortnoxab/renovator/pkg/blob"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// Store persists the output of renovate runs keyed by repo and run id.
+type Store struct {
+	Bucket blob.Bucket
+	// Retention is how long logs are kept, only applies to file stores. Use lifecycle rules for s3 buckets.
+	Retention time.Duration
+}
+
+// NewFromContext returns nil if no log store is configured.
+func NewFromContext(cCtx *cli.Context) (*Store, error) {
+	if cCtx.String("log-store") == "" {
+		return nil, nil
+	}
+	bucket, err := blob.New(cCtx.String("log-store"), blob.S3ConfigFromContext(cCtx))
+	if err != nil {
+		return nil, err
+	}
+	return &Store{Bucket: bucket, Retention: cCtx.Duration("log-retention")}, nil
+}
+
+func (s *Store) Save(ctx context.Context, repo string, id string, log []byte) error {
+	err := s.Bucket.Put(ctx, key(repo, id), bytes.NewReader(log), int64(len(log)))
+	if err != nil {
+		return fmt.Errorf("error saving log for run %s: %w", id, err)
+	}
+	return nil
+}
+
+// Open returns blob.ErrNotFound if there is no log for the run.
+func (s *Store) Open(ctx context.Context, repo string, id string) (*blob.Object, error) {
+	return s.Bucket.Get(ctx, key(repo, id))
+}
+
+// Cleanup removes logs older than the retention.
+func (s *Store) Cleanup() error {
+	dir, ok := s.Bucket.(*blob.Dir)
+	if !ok || s.Retention == 0 {
+		return nil
+	}
+	return dir.DeleteOlderThan(s.Retention)
+}
+
+// CleanupEvery runs Cleanup every interval until ctx is done.
+func (s *Store) CleanupEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := s.Cleanup()
+		if err != nil {
+			logrus.Errorf("error cleaning up logs: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func key(repo string, id string) string {
+	return repo + "/" + id + ".log"
+}
@@ -0,0 +1,28 @@
+package logstore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fortnoxab/renovator/pkg/blob"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCleanupEvery(t *testing.T) {
+	dir := t.TempDir()
+	s := &Store{Bucket: &blob.Dir{Path: dir}, Retention: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.NoError(t, s.Save(ctx, "project1/repo1", "run1", []byte("old")))
+	assert.NoError(t, s.Save(ctx, "project1/repo1", "run2", []byte("new")))
+	old := time.Now().Add(-2 * time.Hour)
+	assert.NoError(t, os.Chtimes(filepath.Join(dir, "project1/repo1/run1.log"), old, old))
+
+	// cleans up once before waiting for the interval
+	cancel()
+	s.CleanupEvery(ctx, time.Hour)
+	assert.NoFileExists(t, filepath.Join(dir, "project1/repo1/run1.log"))
+	assert.FileExists(t, filepath.Join(dir, "project1/repo1/run2.log"))
+}
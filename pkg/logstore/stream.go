@@ -0,0 +1,101 @@
+package logstore
+
+import (
+	"bytes"
+	"sync"
+)
+
+const subscriberBuffer = 256
+
+// Stream keeps the last lines written to it in a ring buffer and broadcasts new lines to subscribers.
+type Stream struct {
+	mu       sync.Mutex
+	lines    []string
+	next     int
+	full     bool
+	partial  []byte
+	subs     map[chan string]struct{}
+	finished bool
+}
+
+func NewStream(maxLines int) *Stream {
+	return &Stream{
+		lines: make([]string, maxLines),
+		subs:  make(map[chan string]struct{}),
+	}
+}
+
+func (s *Stream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.partial = append(s.partial, p...)
+	for {
+		i := bytes.IndexByte(s.partial, '\n')
+		if i < 0 {
+			break
+		}
+		s.add(string(s.partial[:i]))
+		s.partial = s.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+func (s *Stream) add(line string) {
+	s.lines[s.next] = line
+	s.next = (s.next + 1) % len(s.lines)
+	if s.next == 0 {
+		s.full = true
+	}
+
+	for ch := range s.subs {
+		select {
+		case ch <- line:
+		default: // drop lines for subscribers that can't keep up
+		}
+	}
+}
+
+// Subscribe returns the buffered lines and a channel receiving new lines. The channel is closed
+// when the stream is closed. cancel must be called when done.
+func (s *Stream) Subscribe() (backlog []string, lines <-chan string, cancel func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.full {
+		backlog = append(backlog, s.lines[s.next:]...)
+	}
+	backlog = append(backlog, s.lines[:s.next]...)
+
+	ch := make(chan string, subscriberBuffer)
+	if s.finished {
+		close(ch)
+		return backlog, ch, func() {}
+	}
+
+	s.subs[ch] = struct{}{}
+	return backlog, ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.subs[ch]; ok {
+			delete(s.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Close flushes a remaining partial line and closes all subscriptions.
+func (s *Stream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.partial) > 0 {
+		s.add(string(s.partial))
+		s.partial = nil
+	}
+	s.finished = true
+	for ch := range s.subs {
+		delete(s.subs, ch)
+		close(ch)
+	}
+}
@@ -0,0 +1,30 @@
+package logstore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStream(t *testing.T) {
+	s := NewStream(2)
+	_, _ = s.Write([]byte("line1\nline2\nli"))
+	_, _ = s.Write([]byte("ne3\n"))
+
+	backlog, lines, cancel := s.Subscribe()
+	defer cancel()
+	assert.Equal(t, []string{"line2", "line3"}, backlog)
+
+	_, _ = s.Write([]byte("line4\nline5"))
+	assert.Equal(t, "line4", <-lines)
+
+	s.Close()
+	assert.Equal(t, "line5", <-lines)
+	_, ok := <-lines
+	assert.False(t, ok)
+
+	backlog, lines, _ = s.Subscribe()
+	assert.Equal(t, []string{"line4", "line5"}, backlog)
+	_, ok = <-lines
+	assert.False(t, ok)
+}
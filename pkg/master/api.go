@@ -0,0 +1,231 @@
+package master
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	"github.com/fortnoxab/renovator/pkg/blob"
+	"github.com/fortnoxab/renovator/pkg/history"
+	"github.com/fortnoxab/renovator/pkg/overrides"
+	localredis "github.com/fortnoxab/renovator/pkg/redis"
+	"github.com/fortnoxab/renovator/pkg/registry"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+func (m *Master) routes(router *gin.Engine) {
+	router.GET("/api/agents", m.listAgents)
+	router.GET("/api/runs/:id", m.getRun)
+	router.GET("/api/runs/:id/log", m.getRunLog)
+	router.GET("/api/runs/:id/stream", m.streamRun)
+	router.GET("/api/pause", m.getPaused)
+	router.GET("/api/overrides", m.listOverrides)
+	router.GET("/api/canary", m.getCanary)
+}
+
+func (m *Master) adminRoutes(router gin.IRoutes) {
+	router.POST("/api/pause", m.pause)
+	router.POST("/api/resume", m.resume)
+	router.PUT("/api/overrides", m.setOverride)
+	router.DELETE("/api/overrides", m.deleteOverride)
+}
+
+// getCanary compares the error rates of canary and stable runs in the canary window.
+func (m *Master) getCanary(c *gin.Context) {
+	if !m.Router.Canary.Enabled() {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "no canary policy configured"})
+		return
+	}
+	report, err := m.Canary.Compare(c.Request.Context(), m.RedisClient, time.Now())
+	if err != nil {
+		logrus.Error(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func (m *Master) listOverrides(c *gin.Context) {
+	all, err := overrides.List(c.Request.Context(), m.RedisClient)
+	if err != nil {
+		logrus.Error(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, all)
+}
+
+// setOverride replaces the override of the repo or project given as the scope query parameter.
+func (m *Master) setOverride(c *gin.Context) {
+	scope := c.Query("scope")
+	var o overrides.Override
+	if err := c.ShouldBindJSON(&o); err != nil || scope == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "expected scope query parameter and body {\"env\": {}, \"args\": []}"})
+		return
+	}
+
+	err := overrides.Set(c.Request.Context(), m.RedisClient, m.OverrideAllowlist, scope, o)
+	if errors.Is(err, overrides.ErrNotAllowed) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, o)
+}
+
+func (m *Master) deleteOverride(c *gin.Context) {
+	scope := c.Query("scope")
+	if scope == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "expected scope query parameter"})
+		return
+	}
+	err := overrides.Delete(c.Request.Context(), m.RedisClient, scope)
+	if err != nil {
+		logrus.Error(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// pause stops agents from starting jobs in the project given as query parameter, or in all projects.
+func (m *Master) pause(c *gin.Context) {
+	err := localredis.Pause(c.Request.Context(), m.RedisClient, c.Query("project"))
+	if err != nil {
+		logrus.Error(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	m.getPaused(c)
+}
+
+// resume lets agents start jobs in the project given as query parameter, or in all projects.
+func (m *Master) resume(c *gin.Context) {
+	err := localredis.Resume(c.Request.Context(), m.RedisClient, c.Query("project"))
+	if err != nil {
+		logrus.Error(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	m.getPaused(c)
+}
+
+func (m *Master) getPaused(c *gin.Context) {
+	paused, err := localredis.Paused(c.Request.Context(), m.RedisClient)
+	if err != nil {
+		logrus.Error(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if paused == nil {
+		paused = []string{}
+	}
+	c.JSON(http.StatusOK, gin.H{"paused": paused})
+}
+
+func (m *Master) listAgents(c *gin.Context) {
+	agents, err := registry.List(c.Request.Context(), m.RedisClient)
+	if err != nil {
+		logrus.Error(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if agents == nil {
+		agents = []registry.Agent{}
+	}
+	c.JSON(http.StatusOK, agents)
+}
+
+func (m *Master) getRun(c *gin.Context) {
+	run, err := history.Get(c.Request.Context(), m.RedisClient, c.Param("id"))
+	if errors.Is(err, history.ErrNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, run)
+}
+
+func (m *Master) getRunLog(c *gin.Context) {
+	if m.LogStore == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "no log store configured"})
+		return
+	}
+
+	run, err := history.Get(c.Request.Context(), m.RedisClient, c.Param("id"))
+	if errors.Is(err, history.ErrNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	obj, err := m.LogStore.Open(c.Request.Context(), run.Repo, run.ID)
+	if errors.Is(err, blob.ErrNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "log not found"})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer obj.Body.Close()
+
+	// logs can be large, allow more time than the default write timeout of the webserver
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Now().Add(5 * time.Minute))
+
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Status(http.StatusOK)
+	_, err = io.Copy(c.Writer, obj.Body)
+	if err != nil {
+		logrus.Errorf("error streaming log for run %s: %s", run.ID, err)
+	}
+}
+
+// streamRun proxies the live output of a running job from the agent running it.
+func (m *Master) streamRun(c *gin.Context) {
+	run, err := history.Get(c.Request.Context(), m.RedisClient, c.Param("id"))
+	if errors.Is(err, history.ErrNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if run.Status != history.StatusRunning || run.AgentURL == "" {
+		c.AbortWithStatusJSON(http.StatusGone, gin.H{"error": "run is not running", "log": "/api/runs/" + run.ID + "/log"})
+		return
+	}
+
+	target, err := url.Parse(run.AgentURL)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// the stream is open for as long as the job runs
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.FlushInterval = -1
+	proxy.ServeHTTP(c.Writer, c.Request)
+}
@@ -0,0 +1,225 @@
+package master
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fortnoxab/renovator/mocks"
+	"github.com/fortnoxab/renovator/pkg/blob"
+	"github.com/fortnoxab/renovator/pkg/canary"
+	"github.com/fortnoxab/renovator/pkg/history"
+	"github.com/fortnoxab/renovator/pkg/logstore"
+	"github.com/fortnoxab/renovator/pkg/overrides"
+	"github.com/fortnoxab/renovator/pkg/registry"
+	"github.com/fortnoxab/renovator/pkg/webserver"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestGetRunLog(t *testing.T) {
+	redisMock := mocks.NewMockCmdable(t)
+	m := &Master{
+		RedisClient: redisMock,
+		LogStore:    &logstore.Store{Bucket: &blob.Dir{Path: t.TempDir()}},
+	}
+	router := (&webserver.Webserver{Routes: m.routes}).Init()
+
+	run, err := json.Marshal(history.Run{ID: "run1", Repo: "project1/repo1"})
+	assert.NoError(t, err)
+	redisMock.On("Get", mock.Anything, "renovator-run:run1").
+		Return(redis.NewStringResult(string(run), nil))
+	redisMock.On("Get", mock.Anything, "renovator-run:missing").
+		Return(redis.NewStringResult("", redis.Nil))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/runs/run1/log", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	err = m.LogStore.Save(context.Background(), "project1/repo1", "run1", []byte("log line\n"))
+	assert.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/runs/run1/log", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "log line\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/runs/missing/log", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/runs/run1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, bytes.Contains(w.Body.Bytes(), []byte(`"repo":"project1/repo1"`)))
+}
+
+func TestStreamRun(t *testing.T) {
+	agentServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/runs/run1/stream", r.URL.Path)
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("event:log\ndata:line1\n\n"))
+	}))
+	defer agentServer.Close()
+
+	redisMock := mocks.NewMockCmdable(t)
+	m := &Master{RedisClient: redisMock}
+	router := (&webserver.Webserver{Routes: m.routes}).Init()
+
+	running, err := json.Marshal(history.Run{ID: "run1", Status: history.StatusRunning, AgentURL: agentServer.URL})
+	assert.NoError(t, err)
+	redisMock.On("Get", mock.Anything, "renovator-run:run1").
+		Return(redis.NewStringResult(string(running), nil))
+	finished, err := json.Marshal(history.Run{ID: "run2", Status: history.StatusOK, AgentURL: agentServer.URL})
+	assert.NoError(t, err)
+	redisMock.On("Get", mock.Anything, "renovator-run:run2").
+		Return(redis.NewStringResult(string(finished), nil))
+
+	// the reverse proxy needs a real http.ResponseWriter
+	masterServer := httptest.NewServer(router)
+	defer masterServer.Close()
+
+	resp, err := http.Get(masterServer.URL + "/api/runs/run1/stream")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "event:log\ndata:line1\n\n", string(body))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/runs/run2/stream", nil))
+	assert.Equal(t, http.StatusGone, w.Code)
+}
+
+func TestListAgents(t *testing.T) {
+	redisMock := mocks.NewMockCmdable(t)
+	m := &Master{RedisClient: redisMock}
+	router := (&webserver.Webserver{Routes: m.routes}).Init()
+
+	redisMock.On("SMembers", mock.Anything, "renovator-agents").
+		Return(redis.NewStringSliceResult([]string{"agent1"}, nil)).
+		Once()
+	redisMock.On("MGet", mock.Anything, "renovator-agent:agent1").
+		Return(redis.NewSliceResult([]interface{}{`{"id":"agent1","capacity":2,"running":["project1/repo1"]}`}, nil)).
+		Once()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/agents", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	agents := []registry.Agent{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &agents))
+	assert.Equal(t, []registry.Agent{{ID: "agent1", Capacity: 2, Running: []string{"project1/repo1"}}}, agents)
+}
+
+func TestPauseResume(t *testing.T) {
+	redisMock := mocks.NewMockCmdable(t)
+	m := &Master{RedisClient: redisMock}
+	router := (&webserver.Webserver{Routes: m.routes, AdminRoutes: m.adminRoutes, AdminToken: "secret"}).Init()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/pause", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	redisMock.On("SAdd", mock.Anything, "renovator-paused", "project1").
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+	redisMock.On("SMembers", mock.Anything, "renovator-paused").
+		Return(redis.NewStringSliceResult([]string{"project1"}, nil)).
+		Once()
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, adminRequest(http.MethodPost, "/api/pause?project=project1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"paused": ["project1"]}`, w.Body.String())
+
+	redisMock.On("SAdd", mock.Anything, "renovator-paused", "*").
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+	redisMock.On("SMembers", mock.Anything, "renovator-paused").
+		Return(redis.NewStringSliceResult([]string{"project1", "*"}, nil)).
+		Once()
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, adminRequest(http.MethodPost, "/api/pause", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"paused": ["*", "project1"]}`, w.Body.String())
+
+	redisMock.On("Del", mock.Anything, "renovator-paused").
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+	redisMock.On("SMembers", mock.Anything, "renovator-paused").
+		Return(redis.NewStringSliceResult(nil, nil)).
+		Once()
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, adminRequest(http.MethodPost, "/api/resume", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"paused": []}`, w.Body.String())
+}
+
+// adminRequest is a request with the admin token "secret".
+func adminRequest(method string, target string, body io.Reader) *http.Request {
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set("Authorization", "Bearer secret")
+	return req
+}
+
+func TestSetOverride(t *testing.T) {
+	redisMock := mocks.NewMockCmdable(t)
+	m := &Master{RedisClient: redisMock, OverrideAllowlist: overrides.Allowlist{"RENOVATE_PR_*"}}
+	router := (&webserver.Webserver{Routes: m.routes, AdminRoutes: m.adminRoutes, AdminToken: "secret"}).Init()
+
+	w := httptest.NewRecorder()
+	body := `{"env": {"RENOVATE_PR_HOURLY_LIMIT": "2"}, "args": ["--pr-concurrent-limit=5"]}`
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/api/overrides?scope=project1/repo1", strings.NewReader(body)))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	redisMock.On("HSet", mock.Anything, "renovator-overrides", "project1/repo1", `{"env":{"RENOVATE_PR_HOURLY_LIMIT":"2"},"args":["--pr-concurrent-limit=5"]}`).
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, adminRequest(http.MethodPut, "/api/overrides?scope=project1/repo1", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, body, w.Body.String())
+
+	w = httptest.NewRecorder()
+	body = `{"env": {"RENOVATE_TOKEN": "secret"}}`
+	router.ServeHTTP(w, adminRequest(http.MethodPut, "/api/overrides?scope=project1/repo1", strings.NewReader(body)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	body = `{"args": ["--allowed-post-upgrade-commands=.*"]}`
+	router.ServeHTTP(w, adminRequest(http.MethodPut, "/api/overrides?scope=project1", strings.NewReader(body)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGetCanary(t *testing.T) {
+	redisMock := mocks.NewMockCmdable(t)
+	m := &Master{RedisClient: redisMock}
+	router := (&webserver.Webserver{Routes: m.routes}).Init()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/canary", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	m.Router.Canary = canary.Policy{Label: "canary", Percent: 5}
+	m.Canary = canary.Comparison{Window: time.Hour, Threshold: 0.05}
+	redisMock.On("HGetAll", mock.Anything, mock.MatchedBy(func(key string) bool { return strings.HasPrefix(key, "renovator-canary:canary:") })).
+		Return(redis.NewMapStringStringResult(map[string]string{"ok": "1", "error": "1"}, nil))
+	redisMock.On("HGetAll", mock.Anything, mock.MatchedBy(func(key string) bool { return strings.HasPrefix(key, "renovator-canary:stable:") })).
+		Return(redis.NewMapStringStringResult(map[string]string{"ok": "4"}, nil))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/canary", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	report := canary.Report{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.True(t, report.Regression)
+	assert.Zero(t, report.Stable.ErrorRate)
+	assert.Greater(t, report.Canary.Runs, 0)
+}
@@ -2,23 +2,104 @@ package master
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/fortnoxab/renovator/pkg/canary"
 	"github.com/fortnoxab/renovator/pkg/command"
+	"github.com/fortnoxab/renovator/pkg/discovery"
+	"github.com/fortnoxab/renovator/pkg/history"
 	"github.com/fortnoxab/renovator/pkg/kafka"
 	"github.com/fortnoxab/renovator/pkg/leaderelect"
+	"github.com/fortnoxab/renovator/pkg/logstore"
+	"github.com/fortnoxab/renovator/pkg/overrides"
+	"github.com/fortnoxab/renovator/pkg/platform"
 	localredis "github.com/fortnoxab/renovator/pkg/redis"
+	"github.com/fortnoxab/renovator/pkg/registry"
 	"github.com/fortnoxab/renovator/pkg/renovate"
+	"github.com/fortnoxab/renovator/pkg/secrets"
 	"github.com/fortnoxab/renovator/pkg/webserver"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/redis/go-redis/v9"
 	"github.com/robfig/cron/v3"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 )
 
+var discoveredRepos = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "renovator_discovered_repos",
+	Help: "Number of repos found in the last discovery",
+})
+
+var discoveryChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "renovator_discovery_changes",
+	Help: "Number of repos added or removed between discoveries",
+}, []string{"change"})
+
+var agentsAlive = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "renovator_agents_alive",
+	Help: "Number of agents with a valid heartbeat",
+})
+
+var agentsCapacity = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "renovator_agents_capacity",
+	Help: "Total number of renovate processes the agents can run",
+})
+
+var agentsRunning = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "renovator_agents_running",
+	Help: "Total number of renovate processes running on the agents",
+})
+
+var agentsUtilisation = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "renovator_agents_utilisation",
+	Help: "Ratio of running renovate processes to the capacity of the agents",
+})
+
+var removalsPending = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "renovator_discovery_removals_pending",
+	Help: "Number of repos no longer discovered that are not purged until accepted with renovator accept-removals",
+})
+
+var paused = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "renovator_paused",
+	Help: "Set to 1 for paused projects, project is * when all projects are paused",
+}, []string{"project"})
+
+var rebalancedJobs = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "renovator_rebalanced_jobs",
+	Help: "Number of jobs moved from the queues of gone agents to the shared queues",
+})
+
+var canaryRuns = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "renovator_canary_runs",
+	Help: "Number of finished runs in the canary window by group canary or stable",
+}, []string{"group"})
+
+var canaryErrorRate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "renovator_canary_error_rate",
+	Help: "Ratio of failed runs in the canary window by group canary or stable",
+}, []string{"group"})
+
+var canaryRegression = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "renovator_canary_regression",
+	Help: "Set to 1 when the error rate of canary runs is above the stable runs by more than the threshold",
+})
+
+func init() {
+	prometheus.MustRegister(discoveredRepos, discoveryChanges, removalsPending, agentsAlive, agentsCapacity, agentsRunning, agentsUtilisation, paused, rebalancedJobs,
+		canaryRuns, canaryErrorRate, canaryRegression)
+}
+
 type Master struct {
-	Renovator    *renovate.Runner
+	Renovator  *renovate.Runner
+	Discoverer discovery.Discoverer
+	// Sources are the platforms repos are discovered on. Discoverer is used for jobs on the platform configured
+	// in the agents if it is empty.
+	Sources      []Source
 	RedisClient  redis.Cmdable
 	Candidate    *leaderelect.Candidate
 	LeaderElect  bool
@@ -26,14 +107,39 @@ type Master struct {
 	RunFirstTime bool
 	Webserver    *webserver.Webserver
 	Brokers      string
+	// OnboardNewRepos queues repos that are new since the last discovery first in the queue.
+	OnboardNewRepos bool
+	// MaxRemoved is the largest share of the previously discovered repos a discovery may remove before it is treated
+	// as incomplete and nothing is purged, 0 allows any number.
+	MaxRemoved float64
+	LogStore   *logstore.Store
+	// Router decides the labels of jobs and which queue they are pushed to.
+	Router localredis.Router
+	// OverrideAllowlist is the renovate options that can be overridden per repo or project with the api.
+	OverrideAllowlist overrides.Allowlist
+	// Canary decides when the runs of the repos routed to canary agents by Router.Canary are a regression.
+	// It is only used by the master command, see NewComparisonFromContext.
+	Canary canary.Comparison
+
+	regression bool
+}
+
+// Source is a platform repos are discovered on.
+type Source struct {
+	// Platform is set on the jobs of the discovered repos, the agents use the platform in their environment if it is empty.
+	Platform   platform.Platform
+	Discoverer discovery.Discoverer
 }
 
 type autoDiscoverJob struct {
-	ctx            context.Context
-	redisClient    redis.Cmdable
-	renovateRunner *renovate.Runner
-	doLeaderElect  bool
-	candidate      *leaderelect.Candidate
+	ctx             context.Context
+	redisClient     redis.Cmdable
+	sources         []Source
+	doLeaderElect   bool
+	candidate       *leaderelect.Candidate
+	onboardNewRepos bool
+	maxRemoved      float64
+	router          localredis.Router
 }
 
 func NewMasterFromContext(cCtx *cli.Context) (*Master, error) {
@@ -50,26 +156,189 @@ func NewMasterFromContext(cCtx *cli.Context) (*Master, error) {
 			return nil, fmt.Errorf("failed to create cron schedule from value: '%s', err: %w", cs, err)
 		}
 	}
-	return &Master{
-		Renovator:    renovate.NewRunner(&command.Exec{}),
+
+	renovator, err := renovate.NewRunnerFromContext(cCtx, &command.Exec{})
+	if err != nil {
+		return nil, err
+	}
+	discoverer, err := newDiscoverer(cCtx, renovator)
+	if err != nil {
+		return nil, err
+	}
+
+	platforms, err := platform.LoadFromContext(cCtx)
+	if err != nil {
+		return nil, err
+	}
+	var sources []Source
+	for _, p := range platforms {
+		d, err := newPlatformDiscoverer(p, renovator)
+		if err != nil {
+			return nil, fmt.Errorf("error configuring discovery of %s, err: %w", p.Endpoint, err)
+		}
+		sources = append(sources, Source{Platform: p, Discoverer: d})
+	}
+
+	logStore, err := logstore.NewFromContext(cCtx)
+	if err != nil {
+		return nil, fmt.Errorf("error creating log store, err: %w", err)
+	}
+
+	labelRules, err := renovate.ParseLabelRules(cCtx.StringSlice("repo-labels"))
+	if err != nil {
+		return nil, err
+	}
+	rollouts, err := renovate.ParseVersionRollouts(cCtx.StringSlice("renovate-version-rollout"))
+	if err != nil {
+		return nil, err
+	}
+	canaryPolicy, err := canary.NewPolicyFromContext(cCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Master{
+		Renovator:    renovator,
+		Discoverer:   discoverer,
+		Sources:      sources,
 		Candidate:    leaderelect.NewCandidate(rc, cCtx.Duration("election-ttl")),
 		RedisClient:  rc,
 		LeaderElect:  cCtx.Bool("leaderelect"),
 		CronSchedule: cronSchedule,
 		RunFirstTime: cCtx.Bool("run-first-time"),
-		Webserver:    &webserver.Webserver{Port: cCtx.String("port"), EnableMetrics: true},
+		Webserver:    &webserver.Webserver{Port: cCtx.String("port"), EnableMetrics: true, AdminToken: cCtx.String("admin-token")},
 		Brokers:      cCtx.String("kafka-brokers"),
-	}, nil
+
+		OnboardNewRepos: cCtx.Bool("onboard-new-repos"),
+		MaxRemoved:      cCtx.Float64("discovery-max-removed"),
+		LogStore:        logStore,
+		Router: localredis.Router{
+			LabelRules: labelRules,
+			ByProject:  cCtx.Bool("fair-scheduling"),
+			Affinity:   cCtx.Bool("repo-affinity"),
+			Rollouts:   rollouts,
+			Canary:     canaryPolicy,
+		},
+		OverrideAllowlist: cCtx.StringSlice("override-allow"),
+	}
+	m.Webserver.Routes = m.routes
+	m.Webserver.AdminRoutes = m.adminRoutes
+	return m, nil
+}
+
+func newDiscoverer(cCtx *cli.Context, renovator *renovate.Runner) (discovery.Discoverer, error) {
+	return newPlatformDiscoverer(platform.Platform{
+		Endpoint:        cCtx.String("discovery-endpoint"),
+		Token:           cCtx.String("discovery-token"),
+		Discovery:       cCtx.String("discovery"),
+		Projects:        cCtx.StringSlice("discovery-projects"),
+		Topics:          cCtx.StringSlice("discovery-topics"),
+		IncludeArchived: cCtx.Bool("discovery-include-archived"),
+	}, renovator)
+}
+
+// newPlatformDiscoverer returns the discovery of p. Renovate discovers repos on the platform in its environment if
+// p has no platform.
+func newPlatformDiscoverer(p platform.Platform, renovator *renovate.Runner) (discovery.Discoverer, error) {
+	token, err := p.ReadToken()
+	if err != nil {
+		return nil, err
+	}
+	switch d := p.DiscoveryName(); d {
+	case "renovate":
+		if p.Platform == "" {
+			return renovator, nil
+		}
+		credentials, err := p.Credentials()
+		if err != nil {
+			return nil, err
+		}
+		env := append([]string{"RENOVATE_PLATFORM=" + p.Platform, "RENOVATE_ENDPOINT=" + p.Endpoint}, secrets.Environ(credentials)...)
+		return &renovateDiscovery{renovator: renovator, env: env}, nil
+	case "bitbucket":
+		return &discovery.Bitbucket{
+			Endpoint:        p.Endpoint,
+			Token:           token,
+			Projects:        p.Projects,
+			IncludeArchived: p.IncludeArchived,
+		}, nil
+	case "github":
+		return &discovery.GitHub{
+			Endpoint:        p.Endpoint,
+			Token:           token,
+			Orgs:            p.Projects,
+			Topics:          p.Topics,
+			IncludeArchived: p.IncludeArchived,
+		}, nil
+	case "gitlab":
+		return &discovery.GitLab{
+			Endpoint:        p.Endpoint,
+			Token:           token,
+			Groups:          p.Projects,
+			Topics:          p.Topics,
+			IncludeArchived: p.IncludeArchived,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown discovery: '%s'", d)
+	}
+}
+
+// renovateDiscovery runs renovate autodiscovery on another platform than the one in the environment.
+type renovateDiscovery struct {
+	renovator *renovate.Runner
+	env       []string
+}
+
+func (r *renovateDiscovery) Discover(ctx context.Context) ([]string, error) {
+	return r.renovator.DoAutoDiscoverWithEnv(r.env)
+}
+
+func (m *Master) discoverer() discovery.Discoverer {
+	if m.Discoverer != nil {
+		return m.Discoverer
+	}
+	return m.Renovator
+}
+
+// bitbucket returns the platform of the first bitbucket server source, webhooks are assumed to come from it.
+func (m *Master) bitbucket() platform.Platform {
+	for _, source := range m.Sources {
+		if source.Platform.Platform == "bitbucket-server" {
+			return source.Platform
+		}
+	}
+	return platform.Platform{}
+}
+
+func (m *Master) sources() []Source {
+	if len(m.Sources) > 0 {
+		return m.Sources
+	}
+	return []Source{{Discoverer: m.discoverer()}}
 }
 
 func (m *Master) Run(ctx context.Context) error {
+	job := autoDiscoverJob{
+		redisClient:     m.RedisClient,
+		sources:         m.sources(),
+		doLeaderElect:   m.LeaderElect,
+		candidate:       m.Candidate,
+		onboardNewRepos: m.OnboardNewRepos,
+		maxRemoved:      m.MaxRemoved,
+		router:          m.Router,
+		ctx:             ctx,
+	}
+
 	if m.CronSchedule == nil {
-		return doRun(ctx, m.Candidate, m.RedisClient, m.Renovator, m.LeaderElect)
+		if m.Router.Affinity {
+			m.rebalance(ctx)
+		}
+		return job.doRun()
 	}
 
 	if m.RunFirstTime {
 		logrus.Info("running due to --run-first-time")
-		err := doRun(ctx, m.Candidate, m.RedisClient, m.Renovator, m.LeaderElect)
+		err := job.doRun()
 		if err != nil {
 			logrus.Errorf("failed first time run, err: %s", err.Error())
 		}
@@ -78,14 +347,6 @@ func (m *Master) Run(ctx context.Context) error {
 
 	cronRnr := cron.New()
 
-	job := autoDiscoverJob{
-		redisClient:    m.RedisClient,
-		renovateRunner: m.Renovator,
-		doLeaderElect:  m.LeaderElect,
-		candidate:      m.Candidate,
-		ctx:            ctx,
-	}
-
 	cronRnr.Schedule(m.CronSchedule, job)
 
 	wg := &sync.WaitGroup{}
@@ -105,11 +366,33 @@ func (m *Master) Run(ctx context.Context) error {
 		}()
 	}
 
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		m.updateMetrics(ctx)
+	}()
+
+	if m.Router.Affinity {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.rebalanceQueues(ctx)
+		}()
+	}
+
+	if m.LogStore != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.LogStore.CleanupEvery(ctx, time.Hour)
+		}()
+	}
+
 	if m.Brokers != "" {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			kafka.Start(ctx, m.Brokers, m.RedisClient)
+			kafka.Start(ctx, m.Brokers, m.RedisClient, m.Router, m.bitbucket())
 		}()
 	}
 
@@ -126,10 +409,181 @@ func (m *Master) Run(ctx context.Context) error {
 	return nil
 }
 
-func doRun(ctx context.Context, candidate *leaderelect.Candidate, redisClient redis.Cmdable, renovateRunner *renovate.Runner, doLeaderElect bool) error {
+func (m *Master) updateMetrics(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		agents, err := registry.List(ctx, m.RedisClient)
+		if err != nil && ctx.Err() == nil {
+			logrus.Errorf("error listing agents: %s", err)
+		}
+		if err == nil {
+			capacity, running := 0, 0
+			for _, agent := range agents {
+				capacity += agent.Capacity
+				running += len(agent.Running)
+			}
+			agentsAlive.Set(float64(len(agents)))
+			agentsCapacity.Set(float64(capacity))
+			agentsRunning.Set(float64(running))
+			if capacity > 0 {
+				agentsUtilisation.Set(float64(running) / float64(capacity))
+			} else {
+				agentsUtilisation.Set(0)
+			}
+		}
+
+		if m.Router.Canary.Enabled() {
+			m.compareCanary(ctx)
+		}
+
+		projects, err := localredis.Paused(ctx, m.RedisClient)
+		if err != nil && ctx.Err() == nil {
+			logrus.Errorf("error listing paused projects: %s", err)
+		}
+		if err == nil {
+			paused.Reset()
+			for _, project := range projects {
+				paused.WithLabelValues(project).Set(1)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// rebalanceQueues periodically hands the jobs queued for agents that are gone to the other agents.
+func (m *Master) rebalanceQueues(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		m.rebalance(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// rebalance moves the jobs of gone agents if this master is the leader, the moves are not atomic so only one master
+// may do them.
+func (m *Master) rebalance(ctx context.Context) {
+	if m.LeaderElect {
+		isLeader, err := m.Candidate.IsLeader(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				logrus.Errorf("failed to elect leader, err: %s", err)
+			}
+			return
+		}
+		if !isLeader {
+			return
+		}
+	}
+
+	agents, err := registry.List(ctx, m.RedisClient)
+	if err != nil {
+		if ctx.Err() == nil {
+			logrus.Errorf("error listing agents: %s", err)
+		}
+		return
+	}
+	moved, err := localredis.Rebalance(ctx, m.RedisClient, agents)
+	rebalancedJobs.Add(float64(moved))
+	if err != nil && ctx.Err() == nil {
+		logrus.Errorf("error moving jobs of gone agents: %s", err)
+		return
+	}
+	if moved > 0 {
+		logrus.Infof("moved %d jobs of gone agents to the shared queues", moved)
+	}
+}
+
+// compareCanary updates the canary metrics and logs when the canary runs become or stop being a regression.
+func (m *Master) compareCanary(ctx context.Context) {
+	report, err := m.Canary.Compare(ctx, m.RedisClient, time.Now())
+	if err != nil {
+		if ctx.Err() == nil {
+			logrus.Errorf("error comparing canary runs: %s", err)
+		}
+		return
+	}
+	for group, stats := range map[string]canary.Stats{canary.GroupCanary: report.Canary, canary.GroupStable: report.Stable} {
+		canaryRuns.WithLabelValues(group).Set(float64(stats.Runs))
+		canaryErrorRate.WithLabelValues(group).Set(stats.ErrorRate)
+	}
+	if report.Regression {
+		canaryRegression.Set(1)
+	} else {
+		canaryRegression.Set(0)
+	}
+
+	if report.Regression != m.regression {
+		m.regression = report.Regression
+		fields := logrus.Fields{
+			"canaryRuns":      report.Canary.Runs,
+			"canaryErrorRate": report.Canary.ErrorRate,
+			"stableRuns":      report.Stable.Runs,
+			"stableErrorRate": report.Stable.ErrorRate,
+		}
+		if report.Regression {
+			logrus.WithFields(fields).Warn("canary runs fail more often than stable runs")
+		} else {
+			logrus.WithFields(fields).Info("canary runs no longer fail more often than stable runs")
+		}
+	}
+}
+
+// EnqueueDryRun discovers all repos and queues a renovate dry run of them as a named batch.
+// The progress of the batch is tracked in redis by the agents.
+func (m *Master) EnqueueDryRun(ctx context.Context, batch string, mode string) error {
+	if !renovate.ValidDryRun(mode) {
+		return fmt.Errorf("invalid dryrun mode: '%s', available are: %s", mode, strings.Join(renovate.DryRunModes, ","))
+	}
+
+	jobs, err := discover(ctx, m.sources(), m.Router)
+	if err != nil {
+		return err
+	}
+	for i := range jobs {
+		jobs[i].DryRun = mode
+		jobs[i].Batch = batch
+	}
+
+	queued, err := localredis.Enqueue(ctx, m.RedisClient, m.Router, jobs, false)
+	if err != nil {
+		return fmt.Errorf("failed to push repolist to redis, err: %w", err)
+	}
+
+	if queued == 0 {
+		logrus.Warn("zero repos to push to redis")
+		return nil
+	}
+
+	batchKey := localredis.BatchKey(batch)
+	err = m.RedisClient.HSet(ctx, batchKey, "mode", mode, "queued", queued, "created", time.Now().Format(time.RFC3339)).Err()
+	if err != nil {
+		return fmt.Errorf("failed to store batch %s, err: %w", batch, err)
+	}
+	err = m.RedisClient.Expire(ctx, batchKey, localredis.BatchTTL).Err()
+	if err != nil {
+		return fmt.Errorf("failed to set ttl on batch %s, err: %w", batch, err)
+	}
+
+	logrus.Infof("queued dry run of %d repos in batch %s", queued, batch)
+	return nil
+}
+
+func (j autoDiscoverJob) doRun() error {
+	ctx := j.ctx
 
-	if doLeaderElect {
-		isLeader, err := candidate.IsLeader(ctx)
+	if j.doLeaderElect {
+		isLeader, err := j.candidate.IsLeader(ctx)
 		if err != nil {
 			return fmt.Errorf("failed to elect leader, err: %w", err)
 		}
@@ -141,33 +595,122 @@ func doRun(ctx context.Context, candidate *leaderelect.Candidate, redisClient re
 		logrus.Debug("won election, running repo discovery")
 	}
 
-	logrus.Debug("running renovate autodiscover")
-	repos, err := renovateRunner.DoAutoDiscover()
+	logrus.Debug("running repo discovery")
+	jobs, err := discover(ctx, j.sources, j.router)
 	if err != nil {
 		return err
 	}
+	discoveredRepos.Set(float64(len(jobs)))
 
-	reposToQueue, err := localredis.RemoveAlreadyQueued(ctx, redisClient, repos)
+	targets := make([]string, 0, len(jobs))
+	for _, job := range jobs {
+		targets = append(targets, job.Target())
+	}
+	added, err := j.diffDiscovered(targets)
 	if err != nil {
 		return err
 	}
 
-	if len(reposToQueue) == 0 {
-		logrus.Warn("zero repos to push to redis")
-		return nil
+	if j.onboardNewRepos && len(added) > 0 {
+		var onboard []renovate.Job
+		onboard, jobs = partition(jobs, added)
+		queued, err := localredis.Enqueue(ctx, j.redisClient, j.router, onboard, true)
+		if err != nil {
+			return fmt.Errorf("failed to push new repos to redis, err: %w", err)
+		}
+		if queued > 0 {
+			logrus.Infof("pushed %d new repos first in queue", queued)
+		}
+		if len(jobs) == 0 {
+			return nil
+		}
 	}
 
 	logrus.Debug("pushing repo list to redis")
-	err = redisClient.RPush(ctx, localredis.RedisRepoListKey, reposToQueue).Err()
+	queued, err := localredis.Enqueue(ctx, j.redisClient, j.router, jobs, false)
 	if err != nil {
 		return fmt.Errorf("failed to push repolist to redis, err: %w", err)
 	}
+	if queued == 0 {
+		logrus.Warn("zero repos to push to redis")
+	}
 	return nil
 }
 
+// discover returns the jobs of the repos discovered on all sources.
+func discover(ctx context.Context, sources []Source, router localredis.Router) ([]renovate.Job, error) {
+	var jobs []renovate.Job
+	for _, source := range sources {
+		repos, err := source.Discoverer.Discover(ctx)
+		if err != nil {
+			if source.Platform.Endpoint != "" {
+				return nil, fmt.Errorf("error discovering repos on %s, err: %w", source.Platform.Endpoint, err)
+			}
+			return nil, err
+		}
+		for _, repo := range repos {
+			job := router.Job(repo)
+			job.Platform = source.Platform.Platform
+			job.Endpoint = source.Platform.Endpoint
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+// diffDiscovered compares the targets of jobs to the previous discovery, purges removed repos and returns added targets.
+func (j autoDiscoverJob) diffDiscovered(repos []string) ([]string, error) {
+	added, removed, err := localredis.DiffDiscovered(j.ctx, j.redisClient, repos, j.maxRemoved)
+	switch {
+	case errors.Is(err, localredis.ErrDiscoveryShrunk):
+		logrus.WithError(err).Warn("not purging repos no longer discovered, run renovator accept-removals to purge them")
+		removalsPending.Set(float64(len(removed)))
+		removed = nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to diff discovered repos, err: %w", err)
+	default:
+		removalsPending.Set(0)
+	}
+
+	for _, repo := range added {
+		logrus.WithField("repo", repo).Info("discovered new repo")
+		discoveryChanges.WithLabelValues("added").Inc()
+	}
+
+	for _, repo := range removed {
+		logrus.WithField("repo", repo).Info("repo no longer discovered, purging it")
+		discoveryChanges.WithLabelValues("removed").Inc()
+		err = localredis.PurgeRepo(j.ctx, j.redisClient, repo)
+		if err != nil {
+			return nil, fmt.Errorf("failed to purge repo %s, err: %w", repo, err)
+		}
+		err = history.Purge(j.ctx, j.redisClient, repo)
+		if err != nil {
+			return nil, fmt.Errorf("failed to purge history of repo %s, err: %w", repo, err)
+		}
+	}
+	return added, nil
+}
+
+// partition splits jobs into the ones whose target exists in subset and the rest.
+func partition(jobs []renovate.Job, subset []string) (in []renovate.Job, out []renovate.Job) {
+	set := make(map[string]struct{}, len(subset))
+	for _, target := range subset {
+		set[target] = struct{}{}
+	}
+	for _, job := range jobs {
+		if _, ok := set[job.Target()]; ok {
+			in = append(in, job)
+			continue
+		}
+		out = append(out, job)
+	}
+	return in, out
+}
+
 func (j autoDiscoverJob) Run() {
 	logrus.Debug("running autodiscovery")
-	err := doRun(j.ctx, j.candidate, j.redisClient, j.renovateRunner, j.doLeaderElect)
+	err := j.doRun()
 	if err != nil {
 		logrus.Errorf("error when running autodiscovery, err: %s", err.Error())
 	}
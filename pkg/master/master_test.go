@@ -11,7 +11,10 @@ import (
 
 	"github.com/fortnoxab/renovator/mocks"
 	"github.com/fortnoxab/renovator/pkg/leaderelect"
+	"github.com/fortnoxab/renovator/pkg/platform"
+	localredis "github.com/fortnoxab/renovator/pkg/redis"
 	"github.com/fortnoxab/renovator/pkg/renovate"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -34,6 +37,19 @@ func renovateWrite(t *testing.T, mc *mocks.MockCommander, repoList []string) *mo
 		})
 }
 
+func discovered(redisMock *mocks.MockCmdable, previous []string, repoList []string, times int) {
+	redisMock.On("SMembers", mock.Anything, "renovator-discovered").
+		Return(redis.NewStringSliceResult(previous, nil)).
+		Times(times)
+	args := []interface{}{mock.Anything, mock.Anything, []string{"renovator-discovered"}}
+	for _, repo := range repoList {
+		args = append(args, repo)
+	}
+	redisMock.On("EvalSha", args...).
+		Return(redis.NewCmdResult(int64(len(repoList)), nil)).
+		Times(times)
+}
+
 func TestRun(t *testing.T) {
 	logrus.SetLevel(logrus.DebugLevel)
 	logrus.SetFormatter(&logrus.TextFormatter{TimestampFormat: time.RFC3339Nano, FullTimestamp: true})
@@ -52,6 +68,8 @@ func TestRun(t *testing.T) {
 		Return(nil).
 		Once()
 
+	discovered(redisMock, nil, repoList, 1)
+	queues(redisMock, 1)
 	redisMock.On("LRange", mock.Anything, "renovator-joblist", int64(0), int64(-1)).
 		Return(redis.NewStringSliceResult(nil, nil)).
 		Once()
@@ -84,6 +102,8 @@ func TestRunDontPushIfExists(t *testing.T) {
 		Return(nil).
 		Once()
 
+	discovered(redisMock, nil, repoList, 1)
+	queues(redisMock, 1)
 	redisMock.On("LRange", mock.Anything, "renovator-joblist", int64(0), int64(-1)).
 		Return(redis.NewStringSliceResult([]string{"project1/repo1"}, nil)).
 		Once()
@@ -121,6 +141,8 @@ func TestRunWithLeaderElect(t *testing.T) {
 		Return(nil).
 		Once()
 
+	discovered(redisMock, nil, repoList, 1)
+	queues(redisMock, 1)
 	redisMock.On("LRange", mock.Anything, "renovator-joblist", int64(0), int64(-1)).
 		Return(redis.NewStringSliceResult(nil, nil)).
 		Once()
@@ -180,6 +202,8 @@ func TestRunWithSchedule(t *testing.T) {
 		Return(nil).
 		Times(3)
 
+	discovered(redisMock, nil, repoList, 3)
+	queues(redisMock, 3)
 	redisMock.On("LRange", mock.Anything, "renovator-joblist", int64(0), int64(-1)).
 		Return(redis.NewStringSliceResult(nil, nil)).
 		Times(3)
@@ -187,6 +211,13 @@ func TestRunWithSchedule(t *testing.T) {
 		Return(redis.NewIntResult(3, nil)).
 		Times(3)
 
+	redisMock.On("SMembers", mock.Anything, "renovator-agents").
+		Return(redis.NewStringSliceResult(nil, nil)).
+		Once()
+	redisMock.On("SMembers", mock.Anything, "renovator-paused").
+		Return(redis.NewStringSliceResult(nil, nil)).
+		Once()
+
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
 
@@ -228,6 +259,8 @@ func TestRunWithScheduleAndLeaderElection(t *testing.T) {
 		Return(nil).
 		Times(3)
 
+	discovered(redisMock, nil, repoList, 3)
+	queues(redisMock, 3)
 	redisMock.On("LRange", mock.Anything, "renovator-joblist", int64(0), int64(-1)).
 		Return(redis.NewStringSliceResult(nil, nil)).
 		Times(3)
@@ -235,6 +268,13 @@ func TestRunWithScheduleAndLeaderElection(t *testing.T) {
 		Return(redis.NewIntResult(3, nil)).
 		Times(3)
 
+	redisMock.On("SMembers", mock.Anything, "renovator-agents").
+		Return(redis.NewStringSliceResult(nil, nil)).
+		Once()
+	redisMock.On("SMembers", mock.Anything, "renovator-paused").
+		Return(redis.NewStringSliceResult(nil, nil)).
+		Once()
+
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 
 	wg := &sync.WaitGroup{}
@@ -275,3 +315,233 @@ func (s testCronSchedule) Next(t time.Time) time.Time {
 	}
 	return time.Time{}
 }
+
+type staticDiscoverer []string
+
+func (d staticDiscoverer) Discover(ctx context.Context) ([]string, error) {
+	return d, nil
+}
+
+func TestRunWithDiscoverer(t *testing.T) {
+	redisMock := mocks.NewMockCmdable(t)
+	repoList := []string{"project1/repo1", "project2/repo1"}
+	m := &Master{
+		Renovator:   renovate.NewRunner(mocks.NewMockCommander(t)),
+		Discoverer:  staticDiscoverer(repoList),
+		RedisClient: redisMock,
+	}
+
+	discovered(redisMock, nil, repoList, 1)
+	queues(redisMock, 1)
+	redisMock.On("LRange", mock.Anything, "renovator-joblist", int64(0), int64(-1)).
+		Return(redis.NewStringSliceResult(nil, nil)).
+		Once()
+	redisMock.On("RPush", mock.Anything, "renovator-joblist", repoList).
+		Return(redis.NewIntResult(2, nil)).
+		Once()
+
+	err := m.Run(context.Background())
+	assert.NoError(t, err)
+}
+
+func TestRunDiscoveryDiff(t *testing.T) {
+	redisMock := mocks.NewMockCmdable(t)
+	repoList := []string{"project1/repo1", "project1/repo2", "project2/repo1"}
+	m := &Master{
+		Discoverer:      staticDiscoverer(repoList),
+		RedisClient:     redisMock,
+		OnboardNewRepos: true,
+	}
+
+	discovered(redisMock, []string{"project1/repo1", "project2/repo1", "project3/removed"}, repoList, 1)
+	queues(redisMock, 3)
+	redisMock.On("LRange", mock.Anything, "renovator-joblist", int64(0), int64(-1)).
+		Return(redis.NewStringSliceResult([]string{"project3/removed", "project2/repo1"}, nil)).
+		Once()
+	redisMock.On("LRem", mock.Anything, "renovator-joblist", int64(0), "project3/removed").
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+	redisMock.On("LRange", mock.Anything, "renovator-history:project3/removed", int64(0), int64(-1)).
+		Return(redis.NewStringSliceResult([]string{"run1"}, nil)).
+		Once()
+	redisMock.On("Del", mock.Anything, "renovator-history:project3/removed", "renovator-run:run1").
+		Return(redis.NewIntResult(2, nil)).
+		Once()
+	redisMock.On("LRange", mock.Anything, "renovator-joblist", int64(0), int64(-1)).
+		Return(redis.NewStringSliceResult([]string{"project2/repo1"}, nil)).
+		Twice()
+	redisMock.On("LPush", mock.Anything, "renovator-joblist", []string{"project1/repo2"}).
+		Return(redis.NewIntResult(2, nil)).
+		Once()
+	redisMock.On("RPush", mock.Anything, "renovator-joblist", []string{"project1/repo1"}).
+		Return(redis.NewIntResult(3, nil)).
+		Once()
+
+	err := m.Run(context.Background())
+	assert.NoError(t, err)
+}
+
+func TestRunDiscoveryShrunk(t *testing.T) {
+	redisMock := mocks.NewMockCmdable(t)
+	m := &Master{
+		Discoverer:  staticDiscoverer([]string{"project1/repo1", "project3/repo1"}),
+		RedisClient: redisMock,
+		MaxRemoved:  0.5,
+	}
+
+	// the removed repos are not purged but the discovered are still queued
+	redisMock.On("SMembers", mock.Anything, "renovator-discovered").
+		Return(redis.NewStringSliceResult([]string{"project1/repo1", "project1/repo2", "project2/repo1"}, nil)).
+		Once()
+	redisMock.On("Del", mock.Anything, "renovator-discovery-accept-removals").
+		Return(redis.NewIntResult(0, nil)).
+		Once()
+	redisMock.On("SAdd", mock.Anything, "renovator-discovered", []string{"project3/repo1"}).
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+	queues(redisMock, 1)
+	redisMock.On("LRange", mock.Anything, "renovator-joblist", int64(0), int64(-1)).
+		Return(redis.NewStringSliceResult(nil, nil)).
+		Once()
+	redisMock.On("RPush", mock.Anything, "renovator-joblist", []string{"project1/repo1", "project3/repo1"}).
+		Return(redis.NewIntResult(2, nil)).
+		Once()
+
+	err := m.Run(context.Background())
+	assert.NoError(t, err)
+	pending := &dto.Metric{}
+	assert.NoError(t, removalsPending.Write(pending))
+	assert.Equal(t, 2.0, pending.GetGauge().GetValue())
+}
+
+func TestRunWithLabels(t *testing.T) {
+	redisMock := mocks.NewMockCmdable(t)
+	repoList := []string{"project1/repo1", "project2/repo1"}
+	m := &Master{
+		Discoverer:  staticDiscoverer(repoList),
+		RedisClient: redisMock,
+		Router:      localredis.Router{LabelRules: []renovate.LabelRule{{Pattern: "project2/*", Label: "java"}}},
+	}
+
+	discovered(redisMock, nil, repoList, 1)
+	queues(redisMock, 1)
+	redisMock.On("LRange", mock.Anything, "renovator-joblist", int64(0), int64(-1)).
+		Return(redis.NewStringSliceResult(nil, nil)).
+		Once()
+	redisMock.On("RPush", mock.Anything, "renovator-joblist", []string{"project1/repo1"}).
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+	redisMock.On("SAdd", mock.Anything, "renovator-queues", "renovator-joblist:labels=java").
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+	redisMock.On("RPush", mock.Anything, "renovator-joblist:labels=java", []string{"project2/repo1?labels=java"}).
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+
+	err := m.Run(context.Background())
+	assert.NoError(t, err)
+}
+
+func TestEnqueueDryRun(t *testing.T) {
+	redisMock := mocks.NewMockCmdable(t)
+	m := &Master{
+		Discoverer:  staticDiscoverer([]string{"project1/repo1", "project2/repo1"}),
+		RedisClient: redisMock,
+	}
+
+	jobs := []string{"project1/repo1?batch=pending&dryrun=lookup", "project2/repo1?batch=pending&dryrun=lookup"}
+	queues(redisMock, 1)
+	redisMock.On("LRange", mock.Anything, "renovator-joblist", int64(0), int64(-1)).
+		Return(redis.NewStringSliceResult(nil, nil)).
+		Once()
+	redisMock.On("HSet", mock.Anything, "renovator-batch:pending", "mode", "lookup", "queued", 2, "created", mock.AnythingOfType("string")).
+		Return(redis.NewIntResult(3, nil)).
+		Once()
+	redisMock.On("Expire", mock.Anything, "renovator-batch:pending", 7*24*time.Hour).
+		Return(redis.NewBoolResult(true, nil)).
+		Once()
+	redisMock.On("RPush", mock.Anything, "renovator-joblist", jobs).
+		Return(redis.NewIntResult(2, nil)).
+		Once()
+
+	err := m.EnqueueDryRun(context.Background(), "pending", "lookup")
+	assert.NoError(t, err)
+
+	err = m.EnqueueDryRun(context.Background(), "pending", "everything")
+	assert.ErrorContains(t, err, "invalid dryrun mode")
+}
+
+func TestRunWithPlatforms(t *testing.T) {
+	redisMock := mocks.NewMockCmdable(t)
+	m := &Master{
+		Sources: []Source{
+			{
+				Platform:   platform.Platform{Platform: "bitbucket-server", Endpoint: "https://bitbucket.example.com"},
+				Discoverer: staticDiscoverer{"project1/repo1"},
+			},
+			{
+				Platform:   platform.Platform{Platform: "github", Endpoint: "https://api.github.com"},
+				Discoverer: staticDiscoverer{"org1/repo1"},
+			},
+		},
+		RedisClient: redisMock,
+	}
+	jobs := []string{
+		"project1/repo1?endpoint=https%3A%2F%2Fbitbucket.example.com&platform=bitbucket-server",
+		"org1/repo1?endpoint=https%3A%2F%2Fapi.github.com&platform=github",
+	}
+
+	discovered(redisMock, nil, jobs, 1)
+	queues(redisMock, 1)
+	redisMock.On("LRange", mock.Anything, "renovator-joblist", int64(0), int64(-1)).
+		Return(redis.NewStringSliceResult(nil, nil)).
+		Once()
+	redisMock.On("RPush", mock.Anything, "renovator-joblist", jobs).
+		Return(redis.NewIntResult(2, nil)).
+		Once()
+
+	err := m.Run(context.Background())
+	assert.NoError(t, err)
+}
+
+func TestRebalanceOnlyLeader(t *testing.T) {
+	redisMock := mocks.NewMockCmdable(t)
+	m := &Master{
+		RedisClient: redisMock,
+		LeaderElect: true,
+		Candidate:   leaderelect.NewCandidate(redisMock, 2*time.Minute),
+		Router:      localredis.Router{Affinity: true},
+	}
+
+	redisMock.On("SetNX", mock.Anything, "lock.renovator-leader", mock.AnythingOfType("string"), 2*time.Minute).
+		Return(redis.NewBoolResult(false, nil)).
+		Once()
+	redisMock.On("Get", mock.Anything, "lock.renovator-leader").
+		Return(redis.NewStringResult("candidate-other", nil)).
+		Once()
+	m.rebalance(context.Background())
+
+	redisMock.On("SetNX", mock.Anything, "lock.renovator-leader", mock.AnythingOfType("string"), 2*time.Minute).
+		Return(redis.NewBoolResult(true, nil)).
+		Once()
+	redisMock.On("SMembers", mock.Anything, "renovator-agents").
+		Return(redis.NewStringSliceResult(nil, nil)).
+		Once()
+	redisMock.On("SMembers", mock.Anything, "renovator-queues").
+		Return(redis.NewStringSliceResult([]string{"renovator-joblist:agent=gone"}, nil)).
+		Once()
+	redisMock.On("LMove", mock.Anything, "renovator-joblist:agent=gone", "renovator-joblist", "LEFT", "RIGHT").
+		Return(redis.NewStringResult("", redis.Nil)).
+		Once()
+	redisMock.On("SRem", mock.Anything, "renovator-queues", "renovator-joblist:agent=gone").
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+	m.rebalance(context.Background())
+}
+
+// queues expects the queues to be listed times, see localredis.QueueKeys.
+func queues(redisMock *mocks.MockCmdable, times int, keys ...string) {
+	redisMock.On("SMembers", mock.Anything, "renovator-queues").
+		Return(redis.NewStringSliceResult(keys, nil)).
+		Times(times)
+}
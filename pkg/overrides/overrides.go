@@ -0,0 +1,204 @@
+package overrides
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/fortnoxab/renovator/pkg/renovate"
+	"github.com/redis/go-redis/v9"
+)
+
+// redisKey is the hash of overrides by repo or project, see renovate.Job.Scope.
+const redisKey = "renovator-overrides"
+
+// DefaultAllowed are the renovate options that can be overridden if nothing else is configured.
+var DefaultAllowed = []string{
+	"RENOVATE_BRANCH_CONCURRENT_LIMIT",
+	"RENOVATE_PR_CONCURRENT_LIMIT",
+	"RENOVATE_PR_HOURLY_LIMIT",
+	"RENOVATE_RECREATE_WHEN",
+	"RENOVATE_REQUIRE_CONFIG",
+	"RENOVATE_ONBOARDING",
+	"RENOVATE_SCHEDULE",
+	"RENOVATE_TIMEZONE",
+	"RENOVATE_BASE_BRANCHES",
+	"RENOVATE_LABELS",
+}
+
+var ErrNotAllowed = errors.New("override not allowed")
+
+// Override is extra environment and arguments for renovate runs on a repo or the repos of a project.
+type Override struct {
+	Env  map[string]string `json:"env,omitempty"`
+	Args []string          `json:"args,omitempty"`
+}
+
+// Empty is true if the override changes nothing.
+func (o Override) Empty() bool {
+	return len(o.Env) == 0 && len(o.Args) == 0
+}
+
+// Environ returns Env as KEY=VALUE sorted by key.
+func (o Override) Environ() []string {
+	var env []string
+	for _, key := range slices.Sorted(maps.Keys(o.Env)) {
+		env = append(env, key+"="+o.Env[key])
+	}
+	return env
+}
+
+// Merge returns o with other applied on top of it, arguments for the same option are replaced.
+func (o Override) Merge(other Override) Override {
+	merged := Override{}
+	if len(o.Env) > 0 || len(other.Env) > 0 {
+		merged.Env = maps.Clone(o.Env)
+		if merged.Env == nil {
+			merged.Env = map[string]string{}
+		}
+		maps.Copy(merged.Env, other.Env)
+	}
+	for _, arg := range o.Args {
+		if !slices.ContainsFunc(other.Args, func(a string) bool { return argName(a) == argName(arg) }) {
+			merged.Args = append(merged.Args, arg)
+		}
+	}
+	merged.Args = append(merged.Args, other.Args...)
+	return merged
+}
+
+// Allowlist is patterns matching the environment variables renovate reads its options from, ex RENOVATE_PR_*.
+// An argument is allowed if its environment variable is, ex --pr-hourly-limit=2 is RENOVATE_PR_HOURLY_LIMIT.
+type Allowlist []string
+
+// Validate returns ErrNotAllowed if o sets anything that is not allowed or values that could change more than one option.
+func (a Allowlist) Validate(o Override) error {
+	for key, value := range o.Env {
+		if !a.allowed(key) {
+			return fmt.Errorf("environment variable %s, err: %w", key, ErrNotAllowed)
+		}
+		if strings.ContainsAny(value, "\x00\n\r") {
+			return fmt.Errorf("value of %s contains control characters, err: %w", key, ErrNotAllowed)
+		}
+	}
+	for _, arg := range o.Args {
+		name := argName(arg)
+		if name == "" || !strings.HasPrefix(arg, "--") || !strings.Contains(arg, "=") {
+			return fmt.Errorf("argument %s must be --<option>=<value>, err: %w", arg, ErrNotAllowed)
+		}
+		if !a.allowed(name) {
+			return fmt.Errorf("argument %s, err: %w", arg, ErrNotAllowed)
+		}
+		if strings.ContainsAny(arg, "\x00\n\r") {
+			return fmt.Errorf("argument %s contains control characters, err: %w", arg, ErrNotAllowed)
+		}
+	}
+	return nil
+}
+
+func (a Allowlist) allowed(key string) bool {
+	if !strings.HasPrefix(key, "RENOVATE_") || strings.Trim(key, "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_") != "" {
+		return false
+	}
+	for _, pattern := range a {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// argName returns the environment variable of the option set by arg, ex --pr-hourly-limit=2 is RENOVATE_PR_HOURLY_LIMIT.
+func argName(arg string) string {
+	option, _, _ := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+	if option == "" {
+		return ""
+	}
+	return "RENOVATE_" + strings.ToUpper(strings.ReplaceAll(option, "-", "_"))
+}
+
+// Set stores the override of scope, a repo or a project qualified by its platform if it is not on the default platform,
+// see renovate.Job.Scope. An empty override removes it.
+func Set(ctx context.Context, redisClient redis.Cmdable, allow Allowlist, scope string, o Override) error {
+	if scope == "" {
+		return errors.New("scope is required, it is a repo or a project")
+	}
+	if o.Empty() {
+		return Delete(ctx, redisClient, scope)
+	}
+	err := allow.Validate(o)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+	err = redisClient.HSet(ctx, redisKey, scope, string(b)).Err()
+	if err != nil {
+		return fmt.Errorf("error saving override of %s: %w", scope, err)
+	}
+	return nil
+}
+
+// Delete removes the override of scope.
+func Delete(ctx context.Context, redisClient redis.Cmdable, scope string) error {
+	err := redisClient.HDel(ctx, redisKey, scope).Err()
+	if err != nil {
+		return fmt.Errorf("error deleting override of %s: %w", scope, err)
+	}
+	return nil
+}
+
+// List returns all overrides by scope.
+func List(ctx context.Context, redisClient redis.Cmdable) (map[string]Override, error) {
+	values, err := redisClient.HGetAll(ctx, redisKey).Result()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("error listing overrides: %w", err)
+	}
+
+	all := make(map[string]Override, len(values))
+	for scope, value := range values {
+		o := Override{}
+		err = json.Unmarshal([]byte(value), &o)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding override of %s: %w", scope, err)
+		}
+		all[scope] = o
+	}
+	return all, nil
+}
+
+// ForJob returns the override of the project of the repo of job with the override of the repo applied on top of it.
+// Jobs on other platforms than the default use the scopes qualified by their platform, see renovate.Job.Scope.
+func ForJob(ctx context.Context, redisClient redis.Cmdable, job renovate.Job) (Override, error) {
+	scopes := []string{job.Scope(job.Repo)}
+	if project := job.Project(); project != "" {
+		scopes = []string{job.Scope(project), job.Scope(job.Repo)}
+	}
+	values, err := redisClient.HMGet(ctx, redisKey, scopes...).Result()
+	if err != nil {
+		return Override{}, fmt.Errorf("error getting overrides of %s: %w", job.Target(), err)
+	}
+
+	merged := Override{}
+	for i, value := range values {
+		s, ok := value.(string)
+		if !ok {
+			continue
+		}
+		o := Override{}
+		err = json.Unmarshal([]byte(s), &o)
+		if err != nil {
+			return Override{}, fmt.Errorf("error decoding override of %s: %w", scopes[i], err)
+		}
+		merged = merged.Merge(o)
+	}
+	return merged, nil
+}
@@ -0,0 +1,84 @@
+package overrides
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fortnoxab/renovator/mocks"
+	"github.com/fortnoxab/renovator/pkg/renovate"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestValidate(t *testing.T) {
+	allow := Allowlist{"RENOVATE_PR_*", "RENOVATE_LABELS"}
+
+	assert.NoError(t, allow.Validate(Override{
+		Env:  map[string]string{"RENOVATE_PR_HOURLY_LIMIT": "2", "RENOVATE_LABELS": "a,b"},
+		Args: []string{"--pr-concurrent-limit=5", "--labels=a,b"},
+	}))
+
+	for name, o := range map[string]Override{
+		"not allowed env":       {Env: map[string]string{"RENOVATE_TOKEN": "secret"}},
+		"not renovate env":      {Env: map[string]string{"PATH": "/tmp"}},
+		"invalid env name":      {Env: map[string]string{"RENOVATE_PR_X=1 RENOVATE_TOKEN": "x"}},
+		"newline in value":      {Env: map[string]string{"RENOVATE_LABELS": "a\nRENOVATE_TOKEN=x"}},
+		"not allowed arg":       {Args: []string{"--token=secret"}},
+		"arg without value":     {Args: []string{"--pr-hourly-limit"}},
+		"positional arg":        {Args: []string{"project/other-repo"}},
+		"arg with invalid name": {Args: []string{"--pr-x --token=secret"}},
+	} {
+		assert.ErrorIs(t, allow.Validate(o), ErrNotAllowed, name)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	project := Override{
+		Env:  map[string]string{"RENOVATE_PR_HOURLY_LIMIT": "2", "RENOVATE_LABELS": "deps"},
+		Args: []string{"--pr-concurrent-limit=5", "--timezone=UTC"},
+	}
+	repo := Override{
+		Env:  map[string]string{"RENOVATE_PR_HOURLY_LIMIT": "10"},
+		Args: []string{"--pr-concurrent-limit=1"},
+	}
+
+	merged := project.Merge(repo)
+	assert.Equal(t, []string{"RENOVATE_LABELS=deps", "RENOVATE_PR_HOURLY_LIMIT=10"}, merged.Environ())
+	assert.Equal(t, []string{"--timezone=UTC", "--pr-concurrent-limit=1"}, merged.Args)
+	assert.Equal(t, "2", project.Env["RENOVATE_PR_HOURLY_LIMIT"])
+}
+
+func TestForJob(t *testing.T) {
+	redisMock := mocks.NewMockCmdable(t)
+	redisMock.On("HMGet", mock.Anything, "renovator-overrides", "project1", "project1/repo1").
+		Return(redis.NewSliceResult([]interface{}{
+			`{"env":{"RENOVATE_PR_HOURLY_LIMIT":"2"}}`,
+			`{"args":["--pr-concurrent-limit=1"]}`,
+		}, nil)).
+		Once()
+
+	o, err := ForJob(context.Background(), redisMock, renovate.Job{Repo: "project1/repo1"})
+	assert.NoError(t, err)
+	assert.Equal(t, Override{
+		Env:  map[string]string{"RENOVATE_PR_HOURLY_LIMIT": "2"},
+		Args: []string{"--pr-concurrent-limit=1"},
+	}, o)
+
+	// the overrides of a project with the same name on the default platform are not used on other platforms
+	redisMock.On("HMGet", mock.Anything, "renovator-overrides", "github/api.github.com/project1", "github/api.github.com/project1/repo1").
+		Return(redis.NewSliceResult([]interface{}{nil, nil}, nil)).
+		Once()
+	o, err = ForJob(context.Background(), redisMock, renovate.Job{Repo: "project1/repo1", Platform: "github", Endpoint: "https://api.github.com"})
+	assert.NoError(t, err)
+	assert.True(t, o.Empty())
+}
+
+func TestSetEmptyDeletes(t *testing.T) {
+	redisMock := mocks.NewMockCmdable(t)
+	redisMock.On("HDel", mock.Anything, "renovator-overrides", "project1").
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+
+	assert.NoError(t, Set(context.Background(), redisMock, nil, "project1", Override{}))
+}
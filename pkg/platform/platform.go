@@ -0,0 +1,134 @@
+package platform
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Platform is a code host renovate runs against. The master discovers repos on it and the agents run renovate with
+// its credentials on jobs carrying its platform and endpoint.
+type Platform struct {
+	// Platform is the renovate platform, ex bitbucket-server, github or gitlab.
+	Platform string `json:"platform"`
+	// Endpoint is the api endpoint given to renovate as RENOVATE_ENDPOINT.
+	Endpoint string `json:"endpoint"`
+	// Token is read from the environment variable TokenEnv or the file TokenFile if it is not set.
+	Token     string `json:"token,omitempty"`
+	TokenEnv  string `json:"tokenEnv,omitempty"`
+	TokenFile string `json:"tokenFile,omitempty"`
+	Username  string `json:"username,omitempty"`
+	// SecretsOverride lets the secrets of a project replace the credentials of the platform, ex with a RENOVATE_TOKEN
+	// per project. By default the credentials of the platform take precedence.
+	SecretsOverride bool `json:"secretsOverride,omitempty"`
+
+	// Discovery is how repos are discovered, available are: renovate,bitbucket,github,gitlab. Defaults to the
+	// native discovery of the platform.
+	Discovery       string   `json:"discovery,omitempty"`
+	Projects        []string `json:"projects,omitempty"`
+	Topics          []string `json:"topics,omitempty"`
+	IncludeArchived bool     `json:"includeArchived,omitempty"`
+}
+
+// LoadFromContext reads the platforms from the json file in the --platforms flag, nil is returned if it is empty.
+func LoadFromContext(cCtx *cli.Context) ([]Platform, error) {
+	file := cCtx.String("platforms")
+	if file == "" {
+		return nil, nil
+	}
+	return Load(file)
+}
+
+// Load reads a json list of platforms from file.
+func Load(file string) ([]Platform, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading platforms, err: %w", err)
+	}
+	var platforms []Platform
+	err = json.Unmarshal(b, &platforms)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding platforms in %s, err: %w", file, err)
+	}
+	for i, p := range platforms {
+		if p.Platform == "" || p.Endpoint == "" {
+			return nil, fmt.Errorf("platform %d in %s must have platform and endpoint", i, file)
+		}
+		for _, other := range platforms[:i] {
+			if other.Is(p.Platform, p.Endpoint) {
+				return nil, fmt.Errorf("platform %s %s is configured more than once in %s", p.Platform, p.Endpoint, file)
+			}
+		}
+	}
+	return platforms, nil
+}
+
+// Find returns the platform with platform and endpoint.
+func Find(platforms []Platform, platform string, endpoint string) (Platform, bool) {
+	for _, p := range platforms {
+		if p.Is(platform, endpoint) {
+			return p, true
+		}
+	}
+	return Platform{}, false
+}
+
+// Is returns true if p is platform at endpoint, trailing slashes of endpoints are ignored.
+func (p Platform) Is(platform string, endpoint string) bool {
+	return p.Platform == platform && strings.TrimSuffix(p.Endpoint, "/") == strings.TrimSuffix(endpoint, "/")
+}
+
+// DiscoveryName returns Discovery or the native discovery of the platform.
+func (p Platform) DiscoveryName() string {
+	if p.Discovery != "" {
+		return p.Discovery
+	}
+	switch p.Platform {
+	case "bitbucket-server":
+		return "bitbucket"
+	case "github", "gitlab":
+		return p.Platform
+	default:
+		return "renovate"
+	}
+}
+
+// Credentials returns the environment variables renovate authenticates to the platform with.
+func (p Platform) Credentials() (map[string]string, error) {
+	token, err := p.ReadToken()
+	if err != nil {
+		return nil, err
+	}
+	credentials := map[string]string{}
+	if token != "" {
+		credentials["RENOVATE_TOKEN"] = token
+	}
+	if p.Username != "" {
+		credentials["RENOVATE_USERNAME"] = p.Username
+	}
+	return credentials, nil
+}
+
+// ReadToken returns Token or reads it from TokenEnv or TokenFile.
+func (p Platform) ReadToken() (string, error) {
+	switch {
+	case p.Token != "":
+		return p.Token, nil
+	case p.TokenEnv != "":
+		token := os.Getenv(p.TokenEnv)
+		if token == "" {
+			return "", fmt.Errorf("environment variable %s with the token of %s is empty", p.TokenEnv, p.Endpoint)
+		}
+		return token, nil
+	case p.TokenFile != "":
+		b, err := os.ReadFile(p.TokenFile)
+		if err != nil {
+			return "", fmt.Errorf("error reading token of %s, err: %w", p.Endpoint, err)
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	return "", nil
+}
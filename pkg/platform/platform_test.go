@@ -0,0 +1,59 @@
+package platform
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "platforms.json")
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "token"), []byte("github-token\n"), 0o600))
+	assert.NoError(t, os.WriteFile(file, []byte(`[
+		{"platform": "bitbucket-server", "endpoint": "https://bitbucket.example.com/", "tokenEnv": "TEST_BITBUCKET_TOKEN", "username": "renovate"},
+		{"platform": "github", "endpoint": "https://api.github.com", "tokenFile": "`+filepath.Join(dir, "token")+`", "projects": ["org1"]}
+	]`), 0o600))
+	t.Setenv("TEST_BITBUCKET_TOKEN", "bitbucket-token")
+
+	platforms, err := Load(file)
+	assert.NoError(t, err)
+	assert.Len(t, platforms, 2)
+
+	p, ok := Find(platforms, "bitbucket-server", "https://bitbucket.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "bitbucket", p.DiscoveryName())
+	credentials, err := p.Credentials()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"RENOVATE_TOKEN": "bitbucket-token", "RENOVATE_USERNAME": "renovate"}, credentials)
+
+	p, ok = Find(platforms, "github", "https://api.github.com/")
+	assert.True(t, ok)
+	assert.Equal(t, "github", p.DiscoveryName())
+	token, err := p.ReadToken()
+	assert.NoError(t, err)
+	assert.Equal(t, "github-token", token)
+
+	_, ok = Find(platforms, "gitlab", "https://api.github.com")
+	assert.False(t, ok)
+}
+
+func TestLoadInvalid(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "platforms.json")
+
+	assert.NoError(t, os.WriteFile(file, []byte(`[{"platform": "github"}]`), 0o600))
+	_, err := Load(file)
+	assert.ErrorContains(t, err, "must have platform and endpoint")
+
+	assert.NoError(t, os.WriteFile(file, []byte(`[{"platform": "github", "endpoint": "https://api.github.com"}, {"platform": "github", "endpoint": "https://api.github.com/"}]`), 0o600))
+	_, err = Load(file)
+	assert.ErrorContains(t, err, "more than once")
+}
+
+func TestCredentialsMissingToken(t *testing.T) {
+	p := Platform{Platform: "gitlab", Endpoint: "https://gitlab.example.com", TokenEnv: "TEST_MISSING_TOKEN"}
+	_, err := p.Credentials()
+	assert.ErrorContains(t, err, "TEST_MISSING_TOKEN")
+}
@@ -0,0 +1,101 @@
+package redis
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/fortnoxab/renovator/pkg/registry"
+	"github.com/fortnoxab/renovator/pkg/renovate"
+	"github.com/redis/go-redis/v9"
+)
+
+// AgentQueueKey returns the queue of jobs assigned to agent, key is the queue the jobs would have been pushed to otherwise.
+func AgentQueueKey(key string, agent string) string {
+	return key + ":agent=" + agent
+}
+
+// QueueAgent returns the agent a queue created by AgentQueueKey belongs to, or "" for shared queues.
+func QueueAgent(key string) string {
+	_, agent, _ := strings.Cut(key, ":agent=")
+	return agent
+}
+
+// WithAgents returns a copy of the router assigning jobs to the currently live agents if Affinity is enabled.
+func (r Router) WithAgents(ctx context.Context, redisClient redis.Cmdable) (Router, error) {
+	if !r.Affinity {
+		return r, nil
+	}
+	agents, err := registry.List(ctx, redisClient)
+	if err != nil {
+		return r, err
+	}
+	r.Agents = agents
+	return r, nil
+}
+
+// assign picks the agent for job by rendezvous hashing so each repo keeps going to the same agent while it is alive.
+// Only agents having the labels of the job are considered, "" is returned if there are none.
+func (r Router) assign(job renovate.Job) string {
+	var best string
+	var bestScore uint64
+	for _, agent := range r.Agents {
+		if !hasLabels(agent.Labels, job.Labels) {
+			continue
+		}
+		sum := sha256.Sum256([]byte(agent.ID + "\n" + job.Repo))
+		if score := binary.BigEndian.Uint64(sum[:8]); best == "" || score > bestScore {
+			best, bestScore = agent.ID, score
+		}
+	}
+	return best
+}
+
+// Rebalance moves the jobs queued for agents that are not live any more to the shared queues and returns the number of moved jobs.
+func Rebalance(ctx context.Context, redisClient redis.Cmdable, live []registry.Agent) (int, error) {
+	keys, err := QueueKeys(ctx, redisClient)
+	if err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for _, key := range keys {
+		agent := QueueAgent(key)
+		if agent == "" || slices.ContainsFunc(live, func(a registry.Agent) bool { return a.ID == agent }) {
+			continue
+		}
+
+		shared := strings.TrimSuffix(key, ":agent="+agent)
+		err = registerQueue(ctx, redisClient, shared)
+		if err != nil {
+			return moved, err
+		}
+		for {
+			err = redisClient.LMove(ctx, key, shared, "LEFT", "RIGHT").Err()
+			if err == redis.Nil {
+				break
+			}
+			if err != nil {
+				return moved, fmt.Errorf("error moving jobs from %s, err: %w", key, err)
+			}
+			moved++
+		}
+		err = redisClient.SRem(ctx, RedisQueuesKey, key).Err()
+		if err != nil {
+			return moved, fmt.Errorf("error from SRem: %w", err)
+		}
+	}
+	return moved, nil
+}
+
+func hasLabels(labels []string, required []string) bool {
+	for _, label := range required {
+		if !slices.Contains(labels, label) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,79 @@
+package redis
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/fortnoxab/renovator/mocks"
+	"github.com/fortnoxab/renovator/pkg/registry"
+	"github.com/fortnoxab/renovator/pkg/renovate"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouterAffinity(t *testing.T) {
+	router := Router{
+		LabelRules: []renovate.LabelRule{{Pattern: "project2/*", Label: "java"}},
+		ByProject:  true,
+		Affinity:   true,
+	}
+	assert.Equal(t, "renovator-joblist:project=project1", router.QueueKey(router.Job("project1/repo1")))
+
+	router.Agents = []registry.Agent{{ID: "agent1"}, {ID: "agent2"}, {ID: "agent3", Labels: []string{"java"}}}
+	assigned := map[string]bool{}
+	for _, repo := range []string{"project1/repo1", "project1/repo2", "project1/repo3", "project1/repo4", "project1/repo5", "project1/repo6"} {
+		key := router.QueueKey(router.Job(repo))
+		assert.Equal(t, key, router.QueueKey(router.Job(repo)))
+		labels, project := ParseQueueKey(key)
+		assert.Empty(t, labels)
+		assert.Equal(t, "project1", project)
+		assigned[QueueAgent(key)] = true
+	}
+	assert.Len(t, assigned, 3, "repos are spread across agents")
+	assert.Equal(t, "renovator-joblist:labels=java:project=project2:agent=agent3", router.QueueKey(router.Job("project2/repo1")))
+
+	// repos keep their agent when another agent leaves
+	before := router.QueueKey(router.Job("project1/repo1"))
+	other := slices.IndexFunc(router.Agents, func(a registry.Agent) bool { return a.ID != QueueAgent(before) })
+	router.Agents = slices.Delete(slices.Clone(router.Agents), other, other+1)
+	assert.Equal(t, before, router.QueueKey(router.Job("project1/repo1")))
+
+	router.Agents = []registry.Agent{{ID: "agent1"}}
+	assert.Equal(t, "renovator-joblist:labels=java:project=project2", router.QueueKey(router.Job("project2/repo1")))
+}
+
+func TestRebalance(t *testing.T) {
+	redisMock := mocks.NewMockCmdable(t)
+	ctx := context.Background()
+
+	redisMock.On("SMembers", ctx, RedisQueuesKey).
+		Return(redis.NewStringSliceResult([]string{
+			"renovator-joblist:agent=agent1",
+			"renovator-joblist:agent=gone",
+			"renovator-joblist:project=project1:agent=gone",
+		}, nil)).
+		Once()
+	redisMock.On("LMove", ctx, "renovator-joblist:agent=gone", "renovator-joblist", "LEFT", "RIGHT").
+		Return(redis.NewStringResult("project1/repo1", nil)).
+		Once()
+	redisMock.On("LMove", ctx, "renovator-joblist:agent=gone", "renovator-joblist", "LEFT", "RIGHT").
+		Return(redis.NewStringResult("", redis.Nil)).
+		Once()
+	redisMock.On("SRem", ctx, RedisQueuesKey, "renovator-joblist:agent=gone").
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+	redisMock.On("SAdd", ctx, RedisQueuesKey, "renovator-joblist:project=project1").
+		Return(redis.NewIntResult(0, nil)).
+		Once()
+	redisMock.On("LMove", ctx, "renovator-joblist:project=project1:agent=gone", "renovator-joblist:project=project1", "LEFT", "RIGHT").
+		Return(redis.NewStringResult("", redis.Nil)).
+		Once()
+	redisMock.On("SRem", ctx, RedisQueuesKey, "renovator-joblist:project=project1:agent=gone").
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+
+	moved, err := Rebalance(ctx, redisMock, []registry.Agent{{ID: "agent1"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, moved)
+}
@@ -0,0 +1,62 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/urfave/cli/v2"
+)
+
+// RedisPausedKey is the set of paused projects, PauseAll pauses all of them.
+const RedisPausedKey = "renovator-paused"
+
+// PauseAll is the member of RedisPausedKey pausing all projects.
+const PauseAll = "*"
+
+// NewClientFromContext creates a client from the --redis-url flag.
+func NewClientFromContext(cCtx *cli.Context) (*redis.Client, error) {
+	opt, err := redis.ParseURL(cCtx.String("redis-url"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing redis url, err: %w", err)
+	}
+	return redis.NewClient(opt), nil
+}
+
+// Pause stops agents from starting jobs in project, or in all projects if project is empty.
+func Pause(ctx context.Context, redisClient redis.Cmdable, project string) error {
+	if project == "" {
+		project = PauseAll
+	}
+	err := redisClient.SAdd(ctx, RedisPausedKey, project).Err()
+	if err != nil {
+		return fmt.Errorf("error from SAdd: %w", err)
+	}
+	return nil
+}
+
+// Resume lets agents start jobs in project again, or in all projects if project is empty. Resuming all
+// projects also resumes projects that were paused one by one.
+func Resume(ctx context.Context, redisClient redis.Cmdable, project string) error {
+	var err error
+	if project == "" {
+		err = redisClient.Del(ctx, RedisPausedKey).Err()
+	} else {
+		err = redisClient.SRem(ctx, RedisPausedKey, project).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("error resuming, err: %w", err)
+	}
+	return nil
+}
+
+// Paused returns the paused projects.
+func Paused(ctx context.Context, redisClient redis.Cmdable) ([]string, error) {
+	paused, err := redisClient.SMembers(ctx, RedisPausedKey).Result()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("error from SMembers: %w", err)
+	}
+	slices.Sort(paused)
+	return paused, nil
+}
@@ -0,0 +1,225 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/fortnoxab/renovator/pkg/canary"
+	"github.com/fortnoxab/renovator/pkg/registry"
+	"github.com/fortnoxab/renovator/pkg/renovate"
+	"github.com/redis/go-redis/v9"
+)
+
+// Router builds jobs for repos and decides which queue they are pushed to.
+type Router struct {
+	// LabelRules adds labels to jobs for repos matching a pattern so only agents with the labels runs them.
+	LabelRules []renovate.LabelRule
+	// ByProject partitions the queue per project so agents can take jobs fairly across projects.
+	ByProject bool
+	// Affinity pushes jobs to the queue of the agent the repo is assigned to so it can reuse its local cache.
+	// Jobs are pushed to the shared queues if no live agent can run them.
+	Affinity bool
+	// Agents are the live agents jobs are assigned to with Affinity, see WithAgents.
+	Agents []registry.Agent
+	// Rollouts runs other renovate versions on a percent of the repos. Their jobs require the label of the version.
+	Rollouts []renovate.VersionRollout
+	// Canary adds the canary label to the jobs of the repos it picks so they only run on the canary agents.
+	Canary canary.Policy
+}
+
+// Job returns the job for repo.
+func (r Router) Job(repo string) renovate.Job {
+	job := renovate.NewJob(repo, r.LabelRules)
+	if v := renovate.PickVersion(repo, r.Rollouts); v != "" {
+		job.Version = v
+		job.Labels = append(job.Labels, renovate.VersionLabel(v))
+		slices.Sort(job.Labels)
+	}
+	if r.Canary.Picks(repo) && !slices.Contains(job.Labels, r.Canary.Label) {
+		job.Labels = append(job.Labels, r.Canary.Label)
+		slices.Sort(job.Labels)
+	}
+	return job
+}
+
+// QueueKey returns the queue job is pushed to.
+func (r Router) QueueKey(job renovate.Job) string {
+	key := QueueKey(job.Labels, "")
+	if r.ByProject {
+		key = QueueKey(job.Labels, job.Project())
+	}
+	if r.Affinity {
+		if agent := r.assign(job); agent != "" {
+			return AgentQueueKey(key, agent)
+		}
+	}
+	return key
+}
+
+// QueueKey returns the queue for jobs requiring labels in project. Jobs without labels and project are queued in RedisRepoListKey.
+func QueueKey(labels []string, project string) string {
+	key := RedisRepoListKey
+	if len(labels) > 0 {
+		sorted := slices.Clone(labels)
+		slices.Sort(sorted)
+		key += ":labels=" + strings.Join(slices.Compact(sorted), ",")
+	}
+	if project != "" {
+		key += ":project=" + project
+	}
+	return key
+}
+
+// ParseQueueKey returns the labels and project of a queue created by QueueKey.
+func ParseQueueKey(key string) (labels []string, project string) {
+	parts := strings.Split(strings.TrimPrefix(key, RedisRepoListKey), ":")
+	for _, part := range parts {
+		name, value, _ := strings.Cut(part, "=")
+		switch name {
+		case "labels":
+			labels = strings.Split(value, ",")
+		case "project":
+			project = value
+		}
+	}
+	return labels, project
+}
+
+// QueueKeys returns all queues that jobs have been pushed to.
+func QueueKeys(ctx context.Context, redisClient redis.Cmdable) ([]string, error) {
+	keys, err := redisClient.SMembers(ctx, RedisQueuesKey).Result()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("error from SMembers: %w", err)
+	}
+	slices.Sort(keys)
+	return append([]string{RedisRepoListKey}, keys...), nil
+}
+
+// Enqueue pushes the jobs that are not already queued to the queue decided by router and returns the number
+// of pushed jobs. If first is true the jobs are pushed first in the queue. Jobs queued in any other queue are not
+// pushed again, ex when the agent a repo is assigned to changed since it was queued.
+func Enqueue(ctx context.Context, redisClient redis.Cmdable, router Router, jobs []renovate.Job, first bool) (int, error) {
+	router, err := router.WithAgents(ctx, redisClient)
+	if err != nil {
+		return 0, err
+	}
+	queued, err := queuedJobs(ctx, redisClient)
+	if err != nil {
+		return 0, err
+	}
+
+	var keys []string
+	queues := make(map[string][]string)
+	for _, job := range jobs {
+		if queued[job.String()] {
+			continue
+		}
+		queued[job.String()] = true
+		key := router.QueueKey(job)
+		if _, ok := queues[key]; !ok {
+			keys = append(keys, key)
+		}
+		queues[key] = append(queues[key], job.String())
+	}
+
+	pushed := 0
+	for _, key := range keys {
+		err = registerQueue(ctx, redisClient, key)
+		if err != nil {
+			return pushed, err
+		}
+
+		if first {
+			err = redisClient.LPush(ctx, key, queues[key]).Err()
+		} else {
+			err = redisClient.RPush(ctx, key, queues[key]).Err()
+		}
+		if err != nil {
+			return pushed, fmt.Errorf("failed to push jobs to %s, err: %w", key, err)
+		}
+		pushed += len(queues[key])
+	}
+	return pushed, nil
+}
+
+// MoveFirst pushes job first in queue, removing it from where it already is in queue or any other queue.
+func MoveFirst(ctx context.Context, redisClient redis.Cmdable, queue string, job string) error {
+	keys, err := QueueKeys(ctx, redisClient)
+	if err != nil {
+		return err
+	}
+	err = registerQueue(ctx, redisClient, queue)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		err = redisClient.LRem(ctx, key, 0, job).Err()
+		if err != nil {
+			return fmt.Errorf("error from LRem: %w", err)
+		}
+	}
+	err = redisClient.LPush(ctx, queue, job).Err()
+	if err != nil {
+		return fmt.Errorf("failed to push job to %s, err: %w", queue, err)
+	}
+	return nil
+}
+
+// registerQueue adds queue to the queues agents take jobs from, see QueueKeys.
+func registerQueue(ctx context.Context, redisClient redis.Cmdable, queue string) error {
+	if queue == RedisRepoListKey {
+		return nil
+	}
+	err := redisClient.SAdd(ctx, RedisQueuesKey, queue).Err()
+	if err != nil {
+		return fmt.Errorf("error from SAdd: %w", err)
+	}
+	return nil
+}
+
+// PurgeRepo removes all queued jobs for repo, it is matched with the target of the jobs, see renovate.Job.Target.
+func PurgeRepo(ctx context.Context, redisClient redis.Cmdable, repo string) error {
+	keys, err := QueueKeys(ctx, redisClient)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		queued, err := redisClient.LRange(ctx, key, 0, -1).Result()
+		if err != nil && err != redis.Nil {
+			return fmt.Errorf("error from LRange: %w", err)
+		}
+		for _, value := range queued {
+			job, err := renovate.ParseJob(value)
+			if err != nil || job.Target() != repo {
+				continue
+			}
+			err = redisClient.LRem(ctx, key, 0, value).Err()
+			if err != nil {
+				return fmt.Errorf("error from LRem: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// queuedJobs returns the jobs in all queues.
+func queuedJobs(ctx context.Context, redisClient redis.Cmdable) (map[string]bool, error) {
+	keys, err := QueueKeys(ctx, redisClient)
+	if err != nil {
+		return nil, err
+	}
+	queued := map[string]bool{}
+	for _, key := range keys {
+		jobs, err := redisClient.LRange(ctx, key, 0, -1).Result()
+		if err != nil && err != redis.Nil {
+			return nil, fmt.Errorf("error from LRange: %w", err)
+		}
+		for _, job := range jobs {
+			queued[job] = true
+		}
+	}
+	return queued, nil
+}
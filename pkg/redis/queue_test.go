@@ -0,0 +1,130 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/fortnoxab/renovator/mocks"
+	"github.com/fortnoxab/renovator/pkg/canary"
+	"github.com/fortnoxab/renovator/pkg/registry"
+	"github.com/fortnoxab/renovator/pkg/renovate"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueKey(t *testing.T) {
+	assert.Equal(t, "renovator-joblist", QueueKey(nil, ""))
+	assert.Equal(t, "renovator-joblist:labels=full,java", QueueKey([]string{"java", "full", "java"}, ""))
+	assert.Equal(t, "renovator-joblist:labels=java:project=project1", QueueKey([]string{"java"}, "project1"))
+}
+
+func TestParseQueueKey(t *testing.T) {
+	labels, project := ParseQueueKey("renovator-joblist")
+	assert.Empty(t, labels)
+	assert.Equal(t, "", project)
+
+	labels, project = ParseQueueKey("renovator-joblist:labels=full,java:project=project1")
+	assert.Equal(t, []string{"full", "java"}, labels)
+	assert.Equal(t, "project1", project)
+}
+
+func TestRouter(t *testing.T) {
+	router := Router{LabelRules: []renovate.LabelRule{{Pattern: "project2/*", Label: "java"}}}
+	assert.Equal(t, "renovator-joblist", router.QueueKey(router.Job("project1/repo1")))
+	assert.Equal(t, "renovator-joblist:labels=java", router.QueueKey(router.Job("project2/repo1")))
+
+	router.ByProject = true
+	assert.Equal(t, "renovator-joblist:project=project1", router.QueueKey(router.Job("project1/repo1")))
+	assert.Equal(t, "renovator-joblist:labels=java:project=project2", router.QueueKey(router.Job("project2/repo1")))
+}
+
+func TestRouterRollouts(t *testing.T) {
+	router := Router{
+		LabelRules: []renovate.LabelRule{{Pattern: "project1/*", Label: "java"}},
+		Rollouts:   []renovate.VersionRollout{{Version: "next", Percent: 100}},
+	}
+	job := router.Job("project1/repo1")
+	assert.Equal(t, renovate.Job{Repo: "project1/repo1", Version: "next", Labels: []string{"java", "renovate-next"}}, job)
+	assert.Equal(t, "renovator-joblist:labels=java,renovate-next", router.QueueKey(job))
+
+	router.Rollouts = []renovate.VersionRollout{{Version: "next", Percent: 0}}
+	assert.Equal(t, renovate.Job{Repo: "project1/repo1", Labels: []string{"java"}}, router.Job("project1/repo1"))
+}
+
+func TestRouterCanary(t *testing.T) {
+	router := Router{Canary: canary.Policy{Label: "canary", Repos: []string{"project1/*"}}}
+	assert.Equal(t, renovate.Job{Repo: "project1/repo1", Labels: []string{"canary"}}, router.Job("project1/repo1"))
+	assert.Equal(t, renovate.Job{Repo: "project2/repo1"}, router.Job("project2/repo1"))
+}
+
+func TestMoveFirst(t *testing.T) {
+	redisMock := mocks.NewMockCmdable(t)
+	ctx := context.Background()
+	queue := "renovator-joblist:project=project1"
+
+	redisMock.On("SMembers", ctx, RedisQueuesKey).
+		Return(redis.NewStringSliceResult([]string{"renovator-joblist:project=project1:agent=agent1"}, nil)).
+		Once()
+	// the queue may not exist yet so it is registered for the agents to find it
+	redisMock.On("SAdd", ctx, RedisQueuesKey, queue).
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+	// the job is removed from every queue so it is not run twice
+	for _, key := range []string{RedisRepoListKey, "renovator-joblist:project=project1:agent=agent1"} {
+		redisMock.On("LRem", ctx, key, int64(0), "project1/repo1").
+			Return(redis.NewIntResult(0, nil)).
+			Once()
+	}
+	redisMock.On("LPush", ctx, queue, "project1/repo1").
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+	assert.NoError(t, MoveFirst(ctx, redisMock, queue, "project1/repo1"))
+}
+
+func TestEnqueueAgentJoins(t *testing.T) {
+	redisMock := mocks.NewMockCmdable(t)
+	ctx := context.Background()
+	router := Router{Affinity: true}
+
+	// find a repo that moves to agent2 when it joins
+	joined := router
+	joined.Agents = []registry.Agent{{ID: "agent1"}, {ID: "agent2"}}
+	var moved, other string
+	for i := 0; moved == "" || other == ""; i++ {
+		repo := fmt.Sprintf("project1/repo%d", i)
+		if QueueAgent(joined.QueueKey(joined.Job(repo))) == "agent2" && moved == "" {
+			moved = repo
+		} else if other == "" {
+			other = repo
+		}
+	}
+
+	redisMock.On("SMembers", ctx, "renovator-agents").
+		Return(redis.NewStringSliceResult([]string{"agent1", "agent2"}, nil)).
+		Once()
+	redisMock.On("MGet", ctx, "renovator-agent:agent1", "renovator-agent:agent2").
+		Return(redis.NewSliceResult([]interface{}{`{"id":"agent1"}`, `{"id":"agent2"}`}, nil)).
+		Once()
+	redisMock.On("SMembers", ctx, RedisQueuesKey).
+		Return(redis.NewStringSliceResult([]string{"renovator-joblist:agent=agent1"}, nil)).
+		Once()
+	redisMock.On("LRange", ctx, RedisRepoListKey, int64(0), int64(-1)).
+		Return(redis.NewStringSliceResult(nil, nil)).
+		Once()
+	// the repo was queued for agent1 before agent2 joined
+	redisMock.On("LRange", ctx, "renovator-joblist:agent=agent1", int64(0), int64(-1)).
+		Return(redis.NewStringSliceResult([]string{moved}, nil)).
+		Once()
+	key := joined.QueueKey(joined.Job(other))
+	redisMock.On("SAdd", ctx, RedisQueuesKey, key).
+		Return(redis.NewIntResult(0, nil)).
+		Once()
+	redisMock.On("RPush", ctx, key, []string{other}).
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+
+	pushed, err := Enqueue(ctx, redisMock, router, []renovate.Job{router.Job(moved), router.Job(other)}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, pushed)
+}
@@ -2,31 +2,128 @@ package redis
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/fortnoxab/renovator/pkg/renovate"
 	"github.com/redis/go-redis/v9"
 )
 
-const RedisRepoListKey = "renovator-joblist"
+const (
+	RedisRepoListKey    = "renovator-joblist"
+	RedisQueuesKey      = "renovator-queues"
+	RedisDiscoveredKey  = "renovator-discovered"
+	RedisBatchKeyPrefix = "renovator-batch:"
+)
+
+// BatchTTL is how long the progress of a batch is kept in redis.
+const BatchTTL = 7 * 24 * time.Hour
+
+// ErrDiscoveryShrunk is returned when a discovery removes more repos than allowed, it is likely incomplete.
+var ErrDiscoveryShrunk = errors.New("discovery removed too many repos")
+
+// RedisAcceptRemovalsKey lets the next discovery remove any number of repos, see AcceptRemovals.
+const RedisAcceptRemovalsKey = "renovator-discovery-accept-removals"
+
+// acceptRemovalsTTL is how long an accepted removal waits for the next discovery.
+const acceptRemovalsTTL = 24 * time.Hour
+
+// replaceSetScript replaces the members of a set in one step so it is never missing or partial.
+var replaceSetScript = redis.NewScript(`
+redis.call("DEL", KEYS[1])
+for i = 1, #ARGV, 5000 do
+	redis.call("SADD", KEYS[1], unpack(ARGV, i, math.min(i + 4999, #ARGV)))
+end
+return #ARGV
+`)
 
-func RemoveAlreadyQueued(ctx context.Context, redisClient redis.Cmdable, repos []string) ([]string, error) {
-	var reposToQueue []string
-	reposInQueue, err := redisClient.LRange(ctx, RedisRepoListKey, 0, -1).Result()
+// DiffDiscovered stores targets as the last discovered set and returns the targets that were added and
+// removed compared to the previously stored set. Nothing is returned if there is no previous set.
+// If targets is empty or more than maxRemoved of the previous set were removed, 0 allows any number of removed repos,
+// the added targets are stored, the removed are kept and ErrDiscoveryShrunk is returned with both, unless the removal
+// was accepted with AcceptRemovals.
+//
+// Repos discovered before jobs carried their platform are stored as bare repo names, they match the targets
+// of the same repo on any platform.
+func DiffDiscovered(ctx context.Context, redisClient redis.Cmdable, targets []string, maxRemoved float64) (added []string, removed []string, err error) {
+	previous, err := redisClient.SMembers(ctx, RedisDiscoveredKey).Result()
 	if err != nil && err != redis.Nil {
-		return nil, fmt.Errorf("error from LRange: %w", err)
+		return nil, nil, fmt.Errorf("error from SMembers: %w", err)
 	}
 
-	for _, repo := range repos {
-		add := true
-		for _, e := range reposInQueue {
-			if repo == e {
-				add = false
-				break
+	if len(previous) > 0 {
+		added, removed = diff(previous, targets)
+		if len(targets) == 0 || (maxRemoved > 0 && float64(len(removed)) > maxRemoved*float64(len(previous))) {
+			accepted, err := redisClient.Del(ctx, RedisAcceptRemovalsKey).Result()
+			if err != nil {
+				return nil, nil, fmt.Errorf("error from Del: %w", err)
+			}
+			if accepted == 0 {
+				// the removed repos are kept so they are purged once the removal is accepted
+				if len(added) > 0 {
+					err = redisClient.SAdd(ctx, RedisDiscoveredKey, added).Err()
+					if err != nil {
+						return nil, nil, fmt.Errorf("error from SAdd: %w", err)
+					}
+				}
+				return added, removed, fmt.Errorf("%d of %d previously discovered repos are gone, err: %w", len(removed), len(previous), ErrDiscoveryShrunk)
 			}
 		}
-		if add {
-			reposToQueue = append(reposToQueue, repo)
+	}
+	if len(targets) == 0 && len(removed) == 0 {
+		return nil, nil, nil
+	}
+
+	args := make([]interface{}, len(targets))
+	for i, target := range targets {
+		args[i] = target
+	}
+	err = replaceSetScript.Run(ctx, redisClient, []string{RedisDiscoveredKey}, args...).Err()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error storing discovered repos, err: %w", err)
+	}
+	return added, removed, nil
+}
+
+// AcceptRemovals lets the next discovery remove more repos than allowed so they are purged.
+func AcceptRemovals(ctx context.Context, redisClient redis.Cmdable) error {
+	err := redisClient.Set(ctx, RedisAcceptRemovalsKey, "1", acceptRemovalsTTL).Err()
+	if err != nil {
+		return fmt.Errorf("error from Set: %w", err)
+	}
+	return nil
+}
+
+func diff(previous []string, targets []string) (added []string, removed []string) {
+	previousSet := make(map[string]bool, len(previous))
+	for _, target := range previous {
+		previousSet[target] = true
+	}
+	currentSet := make(map[string]bool, len(targets))
+	currentRepos := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		currentSet[target] = true
+		repo := target
+		if job, err := renovate.ParseJob(target); err == nil {
+			repo = job.Repo
 		}
+		currentRepos[repo] = true
+		if !previousSet[target] && !previousSet[repo] {
+			added = append(added, target)
+		}
+	}
+	for _, target := range previous {
+		if currentSet[target] || (!strings.Contains(target, "?") && currentRepos[target]) {
+			continue
+		}
+		removed = append(removed, target)
 	}
-	return reposToQueue, nil
+	return added, removed
+}
+
+// BatchKey returns the key of the hash where the progress of a batch is stored.
+func BatchKey(batch string) string {
+	return RedisBatchKeyPrefix + batch
 }
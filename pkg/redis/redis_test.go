@@ -0,0 +1,94 @@
+package redis
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fortnoxab/renovator/mocks"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// stored mocks replacing the discovered set with targets.
+func stored(redisMock *mocks.MockCmdable, targets []string) *mock.Call {
+	args := []interface{}{mock.Anything, mock.Anything, []string{RedisDiscoveredKey}}
+	for _, target := range targets {
+		args = append(args, target)
+	}
+	return redisMock.On("EvalSha", args...).
+		Return(redis.NewCmdResult(int64(len(targets)), nil))
+}
+
+func TestDiffDiscovered(t *testing.T) {
+	redisMock := mocks.NewMockCmdable(t)
+	ctx := context.Background()
+	previous := []string{"project1/repo1", "project1/repo2", "project2/repo1", "project2/repo2"}
+
+	redisMock.On("SMembers", ctx, RedisDiscoveredKey).
+		Return(redis.NewStringSliceResult(previous, nil)).
+		Times(5)
+	redisMock.On("Del", ctx, RedisAcceptRemovalsKey).
+		Return(redis.NewIntResult(0, nil)).
+		Twice()
+
+	// an empty discovery is not stored
+	_, _, err := DiffDiscovered(ctx, redisMock, nil, 0.5)
+	assert.ErrorIs(t, err, ErrDiscoveryShrunk)
+
+	// a much smaller discovery only stores the added repos and keeps the removed
+	redisMock.On("SAdd", ctx, RedisDiscoveredKey, []string{"project3/repo1"}).
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+	added, removed, err := DiffDiscovered(ctx, redisMock, []string{"project1/repo1", "project3/repo1"}, 0.5)
+	assert.ErrorIs(t, err, ErrDiscoveryShrunk)
+	assert.Equal(t, []string{"project3/repo1"}, added)
+	assert.Equal(t, []string{"project1/repo2", "project2/repo1", "project2/repo2"}, removed)
+
+	// unless the removal is accepted
+	redisMock.On("Del", ctx, RedisAcceptRemovalsKey).
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+	stored(redisMock, []string{"project1/repo1"}).Once()
+	_, removed, err = DiffDiscovered(ctx, redisMock, []string{"project1/repo1"}, 0.5)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"project1/repo2", "project2/repo1", "project2/repo2"}, removed)
+
+	targets := []string{"project1/repo1", "project1/repo2", "project2/repo1", "project3/repo1"}
+	stored(redisMock, targets).Once()
+	added, removed, err = DiffDiscovered(ctx, redisMock, targets, 0.5)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"project3/repo1"}, added)
+	assert.Equal(t, []string{"project2/repo2"}, removed)
+
+	stored(redisMock, []string{"project1/repo1"}).Once()
+	_, _, err = DiffDiscovered(ctx, redisMock, []string{"project1/repo1"}, 0)
+	assert.NoError(t, err)
+}
+
+func TestAcceptRemovals(t *testing.T) {
+	redisMock := mocks.NewMockCmdable(t)
+	ctx := context.Background()
+
+	redisMock.On("Set", ctx, RedisAcceptRemovalsKey, "1", acceptRemovalsTTL).
+		Return(redis.NewStatusResult("OK", nil)).
+		Once()
+	assert.NoError(t, AcceptRemovals(ctx, redisMock))
+}
+
+func TestDiffDiscoveredBareRepos(t *testing.T) {
+	redisMock := mocks.NewMockCmdable(t)
+	ctx := context.Background()
+
+	// repos discovered before jobs carried their platform are not added or removed
+	redisMock.On("SMembers", ctx, RedisDiscoveredKey).
+		Return(redis.NewStringSliceResult([]string{"org1/repo1", "org1/repo2"}, nil)).
+		Once()
+	targets := []string{"org1/repo1?endpoint=https%3A%2F%2Fapi.github.com&platform=github", "org1/repo3?endpoint=https%3A%2F%2Fapi.github.com&platform=github"}
+	stored(redisMock, targets).Once()
+
+	added, removed, err := DiffDiscovered(ctx, redisMock, targets, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"org1/repo3?endpoint=https%3A%2F%2Fapi.github.com&platform=github"}, added)
+	assert.Equal(t, []string{"org1/repo2"}, removed)
+}
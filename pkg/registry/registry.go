@@ -0,0 +1,107 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	agentsKey      = "renovator-agents"
+	agentKeyPrefix = "renovator-agent:"
+)
+
+// Agent is what an agent reports about itself on every heartbeat.
+type Agent struct {
+	ID       string   `json:"id"`
+	Hostname string   `json:"hostname"`
+	Version  string   `json:"version"`
+	URL      string   `json:"url,omitempty"`
+	Capacity int      `json:"capacity"`
+	Labels   []string `json:"labels"`
+	// Running is the repos currently being renovated by the agent.
+	Running   []string  `json:"running"`
+	Heartbeat time.Time `json:"heartbeat"`
+}
+
+func agentKey(id string) string {
+	return agentKeyPrefix + id
+}
+
+// Register adds or refreshes the agent. The agent is considered gone if it is not refreshed within ttl.
+func Register(ctx context.Context, redisClient redis.Cmdable, agent Agent, ttl time.Duration) error {
+	b, err := json.Marshal(agent)
+	if err != nil {
+		return err
+	}
+
+	err = redisClient.Set(ctx, agentKey(agent.ID), b, ttl).Err()
+	if err != nil {
+		return fmt.Errorf("error registering agent %s: %w", agent.ID, err)
+	}
+	err = redisClient.SAdd(ctx, agentsKey, agent.ID).Err()
+	if err != nil {
+		return fmt.Errorf("error adding agent %s to agents: %w", agent.ID, err)
+	}
+	return nil
+}
+
+func Unregister(ctx context.Context, redisClient redis.Cmdable, id string) error {
+	err := redisClient.Del(ctx, agentKey(id)).Err()
+	if err != nil {
+		return fmt.Errorf("error unregistering agent %s: %w", id, err)
+	}
+	err = redisClient.SRem(ctx, agentsKey, id).Err()
+	if err != nil {
+		return fmt.Errorf("error removing agent %s from agents: %w", id, err)
+	}
+	return nil
+}
+
+// List returns all live agents sorted by id. Agents whose heartbeat has expired are removed.
+func List(ctx context.Context, redisClient redis.Cmdable) ([]Agent, error) {
+	ids, err := redisClient.SMembers(ctx, agentsKey).Result()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("error listing agents: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = agentKey(id)
+	}
+	values, err := redisClient.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error getting agents: %w", err)
+	}
+
+	agents := []Agent{}
+	for i, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			err = redisClient.SRem(ctx, agentsKey, ids[i]).Err()
+			if err != nil {
+				return nil, fmt.Errorf("error removing expired agent %s: %w", ids[i], err)
+			}
+			continue
+		}
+
+		agent := Agent{}
+		err = json.Unmarshal([]byte(s), &agent)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding agent %s: %w", ids[i], err)
+		}
+		agents = append(agents, agent)
+	}
+
+	sort.Slice(agents, func(i, j int) bool {
+		return agents[i].ID < agents[j].ID
+	})
+	return agents, nil
+}
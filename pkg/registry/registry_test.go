@@ -0,0 +1,51 @@
+package registry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fortnoxab/renovator/mocks"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestList(t *testing.T) {
+	redisMock := mocks.NewMockCmdable(t)
+
+	redisMock.On("SMembers", mock.Anything, "renovator-agents").
+		Return(redis.NewStringSliceResult([]string{"agent2", "gone", "agent1"}, nil)).
+		Once()
+	redisMock.On("MGet", mock.Anything, "renovator-agent:agent2", "renovator-agent:gone", "renovator-agent:agent1").
+		Return(redis.NewSliceResult([]interface{}{
+			`{"id":"agent2","capacity":2,"running":["project1/repo1"]}`,
+			nil,
+			`{"id":"agent1","capacity":1}`,
+		}, nil)).
+		Once()
+	redisMock.On("SRem", mock.Anything, "renovator-agents", "gone").
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+
+	agents, err := List(context.Background(), redisMock)
+	assert.NoError(t, err)
+	assert.Equal(t, []Agent{
+		{ID: "agent1", Capacity: 1},
+		{ID: "agent2", Capacity: 2, Running: []string{"project1/repo1"}},
+	}, agents)
+}
+
+func TestRegister(t *testing.T) {
+	redisMock := mocks.NewMockCmdable(t)
+
+	redisMock.On("Set", mock.Anything, "renovator-agent:agent1", mock.Anything, 30*time.Second).
+		Return(redis.NewStatusResult("OK", nil)).
+		Once()
+	redisMock.On("SAdd", mock.Anything, "renovator-agents", "agent1").
+		Return(redis.NewIntResult(1, nil)).
+		Once()
+
+	err := Register(context.Background(), redisMock, Agent{ID: "agent1"}, 30*time.Second)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,166 @@
+package renovate
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// DryRunModes are the values renovate accepts for --dry-run.
+var DryRunModes = []string{"extract", "lookup", "full"}
+
+// Job is what is queued in redis. It is a repo optionally followed by options in query string format, ex: project/repo?loglevel=debug&dryrun=lookup
+type Job struct {
+	Repo     string
+	LogLevel string
+	// DryRun is passed to renovate as --dry-run
+	DryRun string
+	// Batch is the name of the batch the job was queued in
+	Batch string
+	// Labels are required by the agent running the job
+	Labels []string
+	// Platform and Endpoint are the code host of the repo given to renovate as RENOVATE_PLATFORM and RENOVATE_ENDPOINT.
+	// The platform configured in the environment of the agent is used if they are empty.
+	Platform string
+	Endpoint string
+	// Version is the name of the installed renovate version the job runs on, see Runner.Versions.
+	Version string
+}
+
+func ParseJob(s string) (Job, error) {
+	repo, options, _ := strings.Cut(s, "?")
+	values, err := url.ParseQuery(options)
+	if err != nil {
+		return Job{}, fmt.Errorf("error parsing options for job: %s, err: %w", s, err)
+	}
+
+	job := Job{
+		Repo:     repo,
+		LogLevel: values.Get("loglevel"),
+		DryRun:   values.Get("dryrun"),
+		Batch:    values.Get("batch"),
+		Platform: values.Get("platform"),
+		Endpoint: values.Get("endpoint"),
+		Version:  values.Get("version"),
+	}
+	if labels := values.Get("labels"); labels != "" {
+		job.Labels = strings.Split(labels, ",")
+	}
+
+	if job.DryRun != "" && !ValidDryRun(job.DryRun) {
+		return Job{}, fmt.Errorf("invalid dryrun mode: '%s' for job: %s", job.DryRun, s)
+	}
+	return job, nil
+}
+
+// Project returns the part of the repo before the first /, or an empty string if there is none.
+func (j Job) Project() string {
+	project, _, found := strings.Cut(j.Repo, "/")
+	if !found {
+		return ""
+	}
+	return project
+}
+
+// RenovateVersion returns Version or DefaultVersion.
+func (j Job) RenovateVersion() string {
+	if j.Version == "" {
+		return DefaultVersion
+	}
+	return j.Version
+}
+
+// Target returns the repo with the platform and endpoint of the job, it identifies the repo across platforms.
+func (j Job) Target() string {
+	return Job{Repo: j.Repo, Platform: j.Platform, Endpoint: j.Endpoint}.String()
+}
+
+// Scope qualifies name, a project or a repo, with the platform and endpoint host of the job, ex
+// github/api.github.com/org1. The secrets and overrides of a scope are only used for jobs on its platform.
+// Jobs on the default platform use name as is.
+func (j Job) Scope(name string) string {
+	if j.Platform == "" {
+		return name
+	}
+	host := j.Endpoint
+	if u, err := url.Parse(j.Endpoint); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	return j.Platform + "/" + host + "/" + name
+}
+
+func (j Job) String() string {
+	values := url.Values{}
+	if j.LogLevel != "" {
+		values.Set("loglevel", j.LogLevel)
+	}
+	if j.DryRun != "" {
+		values.Set("dryrun", j.DryRun)
+	}
+	if j.Batch != "" {
+		values.Set("batch", j.Batch)
+	}
+	if len(j.Labels) > 0 {
+		values.Set("labels", strings.Join(j.Labels, ","))
+	}
+	if j.Platform != "" {
+		values.Set("platform", j.Platform)
+	}
+	if j.Endpoint != "" {
+		values.Set("endpoint", j.Endpoint)
+	}
+	if j.Version != "" {
+		values.Set("version", j.Version)
+	}
+	if len(values) == 0 {
+		return j.Repo
+	}
+	return j.Repo + "?" + values.Encode()
+}
+
+func ValidDryRun(mode string) bool {
+	for _, m := range DryRunModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// LabelRule requires Label for jobs on repos matching Pattern.
+type LabelRule struct {
+	// Pattern is matched with path.Match, ex: PROJECT/* matches all repos in PROJECT
+	Pattern string
+	Label   string
+}
+
+// ParseLabelRules parses rules in the format pattern=label.
+func ParseLabelRules(rules []string) ([]LabelRule, error) {
+	labelRules := make([]LabelRule, 0, len(rules))
+	for _, rule := range rules {
+		pattern, label, ok := strings.Cut(rule, "=")
+		if !ok || pattern == "" || label == "" {
+			return nil, fmt.Errorf("invalid label rule: '%s', must be pattern=label", rule)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern in label rule: '%s', err: %w", rule, err)
+		}
+		labelRules = append(labelRules, LabelRule{Pattern: pattern, Label: label})
+	}
+	return labelRules, nil
+}
+
+// NewJob returns a job for repo with the labels of all matching rules.
+func NewJob(repo string, rules []LabelRule) Job {
+	job := Job{Repo: repo}
+	for _, rule := range rules {
+		if ok, _ := path.Match(rule.Pattern, repo); ok && !slices.Contains(job.Labels, rule.Label) {
+			job.Labels = append(job.Labels, rule.Label)
+		}
+	}
+	sort.Strings(job.Labels)
+	return job
+}
@@ -0,0 +1,62 @@
+package renovate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseJob(t *testing.T) {
+	job, err := ParseJob("project1/repo1")
+	assert.NoError(t, err)
+	assert.Equal(t, Job{Repo: "project1/repo1"}, job)
+	assert.Equal(t, "project1/repo1", job.String())
+
+	job, err = ParseJob("project1/repo1?loglevel=debug")
+	assert.NoError(t, err)
+	assert.Equal(t, Job{Repo: "project1/repo1", LogLevel: "debug"}, job)
+
+	job, err = ParseJob("project1/repo1?dryrun=lookup&batch=pending updates")
+	assert.NoError(t, err)
+	assert.Equal(t, Job{Repo: "project1/repo1", DryRun: "lookup", Batch: "pending updates"}, job)
+	assert.Equal(t, "project1/repo1?batch=pending+updates&dryrun=lookup", job.String())
+
+	job, err = ParseJob("org1/repo1?endpoint=https%3A%2F%2Fapi.github.com&platform=github&labels=java")
+	assert.NoError(t, err)
+	assert.Equal(t, Job{Repo: "org1/repo1", Platform: "github", Endpoint: "https://api.github.com", Labels: []string{"java"}}, job)
+	assert.Equal(t, "org1/repo1?endpoint=https%3A%2F%2Fapi.github.com&labels=java&platform=github", job.String())
+	assert.Equal(t, "org1/repo1?endpoint=https%3A%2F%2Fapi.github.com&platform=github", job.Target())
+
+	job, err = ParseJob("project1/repo1?version=next&labels=renovate-next")
+	assert.NoError(t, err)
+	assert.Equal(t, Job{Repo: "project1/repo1", Version: "next", Labels: []string{"renovate-next"}}, job)
+	assert.Equal(t, "next", job.RenovateVersion())
+	assert.Equal(t, "project1/repo1", job.Target())
+	assert.Equal(t, DefaultVersion, Job{Repo: "project1/repo1"}.RenovateVersion())
+
+	_, err = ParseJob("project1/repo1?dryrun=all")
+	assert.ErrorContains(t, err, "invalid dryrun mode")
+}
+
+func TestNewJob(t *testing.T) {
+	rules, err := ParseLabelRules([]string{"JAVA/*=java", "JAVA/*=full", "*/dotnet-*=full"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, Job{Repo: "GO/repo1"}, NewJob("GO/repo1", rules))
+	assert.Equal(t, Job{Repo: "JAVA/repo1", Labels: []string{"full", "java"}}, NewJob("JAVA/repo1", rules))
+	assert.Equal(t, "NET/dotnet-api?labels=full", NewJob("NET/dotnet-api", rules).String())
+
+	job, err := ParseJob("JAVA/repo1?labels=full,java")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"full", "java"}, job.Labels)
+
+	_, err = ParseLabelRules([]string{"JAVA/*"})
+	assert.ErrorContains(t, err, "invalid label rule")
+}
+
+func TestJobScope(t *testing.T) {
+	assert.Equal(t, "project1", Job{Repo: "project1/repo1"}.Scope("project1"))
+	job := Job{Repo: "org1/repo1", Platform: "github", Endpoint: "https://api.github.com/"}
+	assert.Equal(t, "github/api.github.com/org1", job.Scope(job.Project()))
+	assert.Equal(t, "github/api.github.com/org1/repo1", job.Scope(job.Repo))
+}
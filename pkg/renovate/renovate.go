@@ -1,16 +1,27 @@
 package renovate
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/fortnoxab/renovator/pkg/command"
+	"github.com/urfave/cli/v2"
 )
 
 type Runner struct {
 	commander command.Commander
+	// Bin is the renovate command, defaults to renovate in PATH.
+	Bin string
+	// Args are added to the arguments of every run and discovery.
+	Args []string
+	// Versions are the commands of other installed renovate versions by name, jobs select them with Job.Version.
+	Versions map[string]string
 }
 
 func NewRunner(c command.Commander) *Runner {
@@ -19,23 +30,100 @@ func NewRunner(c command.Commander) *Runner {
 	}
 }
 
-func (r *Runner) RunRenovate(repo string) error {
-	repo, options, _ := strings.Cut(repo, "?")
+// NewRunnerFromContext returns a runner configured with the --renovate-bin, --renovate-args and --renovate-version flags.
+func NewRunnerFromContext(cCtx *cli.Context, c command.Commander) (*Runner, error) {
+	versions, err := ParseVersions(cCtx.StringSlice("renovate-version"))
+	if err != nil {
+		return nil, err
+	}
+	r := NewRunner(c)
+	r.Bin = cCtx.String("renovate-bin")
+	r.Args = strings.Fields(cCtx.String("renovate-args"))
+	r.Versions = versions
+	return r, nil
+}
+
+// bin returns the command of version.
+func (r *Runner) bin(version string) (string, error) {
+	if version != "" && version != DefaultVersion {
+		bin, ok := r.Versions[version]
+		if !ok {
+			return "", fmt.Errorf("renovate version %s is not installed", version)
+		}
+		return bin, nil
+	}
+	if r.Bin != "" {
+		return r.Bin, nil
+	}
+	return "renovate", nil
+}
+
+type RunOptions struct {
+	// Output receives a copy of the output of renovate with the Redact values replaced.
+	Output io.Writer
+	// Env is added to the environment of renovate.
+	Env []string
+	// Args are added to the arguments of renovate before the repo.
+	Args []string
+	// Redact is secret values that are replaced in the output and result of renovate.
+	Redact []string
+}
 
-	env := []string{}
-	switch options {
-	case "loglevel=debug":
-		env = []string{"LOG_LEVEL=debug"}
+// RunRenovate runs renovate on the repo in the job and returns the result parsed from its log.
+// The output of renovate is forwarded to stdout prefixed with the repo.
+func (r *Runner) RunRenovate(repo string, opts RunOptions) (*RunResult, error) {
+	job, err := ParseJob(repo)
+	if err != nil {
+		return nil, err
 	}
-	err := r.commander.RunWithEnv(env, "renovate", repo)
+	bin, err := r.bin(job.Version)
 	if err != nil {
-		return fmt.Errorf("error running renovate on repo: %s, err: %w", repo, err)
+		return nil, err
 	}
-	return nil
+
+	env := []string{"LOG_FORMAT=json"}
+	if job.LogLevel != "" {
+		env = append(env, "LOG_LEVEL="+job.LogLevel)
+	}
+	if job.Platform != "" {
+		env = append(env, "RENOVATE_PLATFORM="+job.Platform)
+	}
+	if job.Endpoint != "" {
+		env = append(env, "RENOVATE_ENDPOINT="+job.Endpoint)
+	}
+	env = append(env, opts.Env...)
+
+	args := slices.Clone(r.Args)
+	if job.DryRun != "" {
+		args = append(args, "--dry-run="+job.DryRun)
+	}
+	args = append(args, opts.Args...)
+	args = append(args, job.Repo)
+
+	parser := newLogParser(job.Repo, os.Stdout, opts.Output)
+	parser.redact = newRedactor(opts.Redact)
+	start := time.Now()
+	err = r.commander.RunWithOutput(parser, env, bin, args...)
+	parser.Flush()
+
+	result := parser.result
+	if result.Duration == 0 {
+		result.Duration = time.Since(start)
+	}
+	if err != nil {
+		return result, fmt.Errorf("error running renovate on repo: %s, err: %w", job.Repo, err)
+	}
+	return result, nil
 }
 
 // DoAutoDiscover returns a list of repos
 func (r *Runner) DoAutoDiscover() ([]string, error) {
+	return r.DoAutoDiscoverWithEnv(nil)
+}
+
+// DoAutoDiscoverWithEnv returns a list of repos discovered by renovate with env added to its environment,
+// ex RENOVATE_PLATFORM and RENOVATE_ENDPOINT of another platform.
+func (r *Runner) DoAutoDiscoverWithEnv(env []string) ([]string, error) {
 
 	file, err := createTempFile()
 	if err != nil {
@@ -43,7 +131,13 @@ func (r *Runner) DoAutoDiscover() ([]string, error) {
 	}
 	defer os.Remove(file.Name())
 
-	err = r.commander.Run("renovate", "--write-discovered-repos", file.Name())
+	bin, _ := r.bin("")
+	args := append(slices.Clone(r.Args), "--write-discovered-repos", file.Name())
+	if len(env) > 0 {
+		err = r.commander.RunWithEnv(env, bin, args...)
+	} else {
+		err = r.commander.Run(bin, args...)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error running renovate discovery, err: %w", err)
 	}
@@ -64,6 +158,11 @@ func (r *Runner) DoAutoDiscover() ([]string, error) {
 
 }
 
+// Discover runs renovate autodiscovery, it makes Runner usable as a discovery.Discoverer.
+func (r *Runner) Discover(ctx context.Context) ([]string, error) {
+	return r.DoAutoDiscover()
+}
+
 func createTempFile() (*os.File, error) {
 	file, err := os.CreateTemp("", "renovator_")
 	if err != nil {
@@ -0,0 +1,109 @@
+package renovate
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/fortnoxab/renovator/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const renovateLog = `{"name":"renovate","level":30,"msg":"Repository started","repository":"project1/repo1"}
+(node:1) Warning: this is not json
+{"name":"renovate","level":30,"msg":"PR created","pr":12,"prTitle":"Update dependency a to v2"}
+{"name":"renovate","level":30,"msg":"PR updated","pr":10,"prTitle":"Update dependency b to v1.2"}
+{"name":"renovate","level":20,"msg":"branches info extended","branchesInformation":[{"branchName":"renovate/a"},{"branchName":"renovate/b"}]}
+{"name":"renovate","level":50,"msg":"Repository has invalid config","err":{"message":"config validation error"}}
+{"name":"renovate","level":20,"msg":"Repository result: done, enabled=true, onboarded=true","status":"onboarded"}
+{"name":"renovate","level":30,"msg":"Repository finished","cloned":true,"durationMs":1500}`
+
+func TestRunRenovate(t *testing.T) {
+	commanderMock := mocks.NewMockCommander(t)
+	r := NewRunner(commanderMock)
+
+	commanderMock.On("RunWithOutput", mock.Anything, []string{"LOG_FORMAT=json", "LOG_LEVEL=debug"}, "renovate", "--dry-run=lookup", "project1/repo1").
+		Run(func(args mock.Arguments) {
+			// write in uneven chunks to make sure lines are buffered
+			w := args[0].(io.Writer)
+			_, _ = w.Write([]byte(renovateLog[:50]))
+			_, _ = w.Write([]byte(renovateLog[50:]))
+		}).
+		Return(nil).
+		Once()
+
+	output := &bytes.Buffer{}
+	branches := 2
+	result, err := r.RunRenovate("project1/repo1?loglevel=debug&dryrun=lookup", RunOptions{Output: output})
+	assert.NoError(t, err)
+	assert.Equal(t, &RunResult{
+		Repo:       "project1/repo1",
+		Status:     "onboarded",
+		PRsCreated: 1,
+		PRsUpdated: 1,
+		Branches:   &branches,
+		Errors:     []string{"Repository has invalid config: config validation error"},
+		Duration:   1500 * time.Millisecond,
+	}, result)
+	assert.Equal(t, renovateLog+"\n", output.String())
+}
+
+func TestRunRenovateError(t *testing.T) {
+	commanderMock := mocks.NewMockCommander(t)
+	r := NewRunner(commanderMock)
+
+	commanderMock.On("RunWithOutput", mock.Anything, []string{"LOG_FORMAT=json"}, "renovate", "project1/repo1").
+		Return(errors.New("exit status 1")).
+		Once()
+
+	result, err := r.RunRenovate("project1/repo1", RunOptions{})
+	assert.ErrorContains(t, err, "exit status 1")
+	assert.Equal(t, "project1/repo1", result.Repo)
+	assert.Nil(t, result.Branches)
+	assert.NotZero(t, result.Duration)
+}
+
+func TestRunRenovateVersion(t *testing.T) {
+	commanderMock := mocks.NewMockCommander(t)
+	r := NewRunner(commanderMock)
+	r.Bin = "/opt/renovate/bin/renovate"
+	r.Args = []string{"--onboarding=false"}
+	r.Versions = map[string]string{"next": "/opt/renovate-next/bin/renovate"}
+
+	commanderMock.On("RunWithOutput", mock.Anything, []string{"LOG_FORMAT=json"}, "/opt/renovate/bin/renovate", "--onboarding=false", "project1/repo1").
+		Return(nil).
+		Once()
+	commanderMock.On("RunWithOutput", mock.Anything, []string{"LOG_FORMAT=json"}, "/opt/renovate-next/bin/renovate", "--onboarding=false", "project1/repo2").
+		Return(nil).
+		Once()
+
+	_, err := r.RunRenovate("project1/repo1", RunOptions{})
+	assert.NoError(t, err)
+	_, err = r.RunRenovate("project1/repo2?version=next", RunOptions{})
+	assert.NoError(t, err)
+	_, err = r.RunRenovate("project1/repo3?version=39", RunOptions{})
+	assert.ErrorContains(t, err, "renovate version 39 is not installed")
+}
+
+func TestRunRenovateRedact(t *testing.T) {
+	commanderMock := mocks.NewMockCommander(t)
+	r := NewRunner(commanderMock)
+
+	commanderMock.On("RunWithOutput", mock.Anything, []string{"LOG_FORMAT=json", "NPM_TOKEN=s3cr\"t"}, "renovate", "project1/repo1").
+		Run(func(args mock.Arguments) {
+			w := args[0].(io.Writer)
+			_, _ = w.Write([]byte(`{"level":50,"msg":"auth failed","err":{"message":"bad token s3cr\"t"}}` + "\n"))
+			_, _ = w.Write([]byte("npm ERR! token s3cr\"t\n"))
+		}).
+		Return(nil).
+		Once()
+
+	output := &bytes.Buffer{}
+	result, err := r.RunRenovate("project1/repo1", RunOptions{Output: output, Env: []string{"NPM_TOKEN=s3cr\"t"}, Redact: []string{"s3cr\"t"}})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"level":50,"msg":"auth failed","err":{"message":"bad token **redacted**"}}`+"\nnpm ERR! token **redacted**\n", output.String())
+	assert.Equal(t, []string{"auth failed: bad token **redacted**"}, result.Errors)
+}
@@ -0,0 +1,155 @@
+package renovate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bunyan log level used by renovate for errors.
+const logLevelError = 50
+
+// RunResult is the outcome of a renovate run, parsed from the json log of renovate.
+type RunResult struct {
+	Repo string `json:"repo"`
+	// Status is the repository result reported by renovate, ex: onboarded, disabled, ...
+	Status     string `json:"status,omitempty"`
+	PRsCreated int    `json:"prsCreated"`
+	PRsUpdated int    `json:"prsUpdated"`
+	// Branches is the number of branches of the repo, renovate only logs them at debug level so it is nil otherwise.
+	Branches *int          `json:"branches,omitempty"`
+	Errors   []string      `json:"errors,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+type logLine struct {
+	Level      int    `json:"level"`
+	Msg        string `json:"msg"`
+	Status     string `json:"status"`
+	DurationMs *int64 `json:"durationMs"`
+	Err        *struct {
+		Message string `json:"message"`
+	} `json:"err"`
+	BranchesInformation []json.RawMessage `json:"branchesInformation"`
+}
+
+// logParser is a line buffered writer that parses renovate json log lines into a RunResult
+// and forwards every line, with secrets redacted, to out prefixed with the repo and to raw without the prefix.
+type logParser struct {
+	mu     sync.Mutex
+	out    io.Writer
+	raw    io.Writer
+	result *RunResult
+	buf    []byte
+	// redact replaces secrets in lines before they are written or parsed, nil if there are none.
+	redact *strings.Replacer
+}
+
+func newLogParser(repo string, out io.Writer, raw io.Writer) *logParser {
+	return &logParser{
+		out:    out,
+		raw:    raw,
+		result: &RunResult{Repo: repo},
+	}
+}
+
+// redacted replaces secrets in the output of renovate.
+const redacted = "**redacted**"
+
+// newRedactor returns a replacer of secrets as they are written in plain text and in json strings, nil if there are none.
+func newRedactor(secrets []string) *strings.Replacer {
+	// longer secrets first so a secret containing another is replaced as a whole
+	secrets = slices.Clone(secrets)
+	slices.SortFunc(secrets, func(a, b string) int { return len(b) - len(a) })
+
+	var oldnew []string
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		oldnew = append(oldnew, secret, redacted)
+		if b, err := json.Marshal(secret); err == nil {
+			if escaped := string(b[1 : len(b)-1]); escaped != secret {
+				oldnew = append(oldnew, escaped, redacted)
+			}
+		}
+	}
+	if len(oldnew) == 0 {
+		return nil
+	}
+	return strings.NewReplacer(oldnew...)
+}
+
+func (p *logParser) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		p.parseLine(p.buf[:i])
+		p.buf = p.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+// Flush parses a remaining line without a trailing newline.
+func (p *logParser) Flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.buf) > 0 {
+		p.parseLine(p.buf)
+		p.buf = nil
+	}
+}
+
+func (p *logParser) parseLine(line []byte) {
+	line = bytes.TrimRight(line, "\r")
+	if len(line) == 0 {
+		return
+	}
+	if p.redact != nil {
+		line = []byte(p.redact.Replace(string(line)))
+	}
+	fmt.Fprintf(p.out, "[%s] %s\n", p.result.Repo, line)
+	if p.raw != nil {
+		fmt.Fprintf(p.raw, "%s\n", line)
+	}
+
+	l := &logLine{}
+	if json.Unmarshal(line, l) != nil {
+		return // not all output from renovate is json, ex: node warnings
+	}
+
+	switch {
+	case l.Msg == "PR created":
+		p.result.PRsCreated++
+	case l.Msg == "PR updated":
+		p.result.PRsUpdated++
+	case l.Msg == "branches info extended":
+		branches := len(l.BranchesInformation)
+		p.result.Branches = &branches
+	case l.Msg == "Repository finished":
+		if l.DurationMs != nil {
+			p.result.Duration = time.Duration(*l.DurationMs) * time.Millisecond
+		}
+	case strings.HasPrefix(l.Msg, "Repository result:"):
+		p.result.Status = l.Status
+	}
+
+	if l.Level >= logLevelError {
+		msg := l.Msg
+		if l.Err != nil && l.Err.Message != "" {
+			msg += ": " + l.Err.Message
+		}
+		p.result.Errors = append(p.result.Errors, msg)
+	}
+}
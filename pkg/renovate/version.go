@@ -0,0 +1,78 @@
+package renovate
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultVersion is the name of the renovate in Runner.Bin, it is used by jobs without a version.
+const DefaultVersion = "default"
+
+// VersionLabel is the label of agents having version installed, jobs on the version require it.
+func VersionLabel(version string) string {
+	return "renovate-" + version
+}
+
+// ParseVersions parses installed renovate versions in the format name=path.
+func ParseVersions(versions []string) (map[string]string, error) {
+	parsed := make(map[string]string, len(versions))
+	for _, v := range versions {
+		name, bin, ok := strings.Cut(v, "=")
+		if !ok || name == "" || bin == "" || name == DefaultVersion || strings.ContainsAny(name, ",&?=") {
+			return nil, fmt.Errorf("invalid renovate version: '%s', must be name=path", v)
+		}
+		parsed[name] = bin
+	}
+	return parsed, nil
+}
+
+// VersionRollout runs renovate Version on Percent of the repos.
+type VersionRollout struct {
+	Version string
+	Percent float64
+}
+
+// ParseVersionRollouts parses rollouts in the format version=percent.
+func ParseVersionRollouts(rollouts []string) ([]VersionRollout, error) {
+	parsed := make([]VersionRollout, 0, len(rollouts))
+	total := 0.0
+	for _, r := range rollouts {
+		version, p, ok := strings.Cut(r, "=")
+		percent, err := strconv.ParseFloat(p, 64)
+		if !ok || version == "" || err != nil || percent < 0 || percent > 100 {
+			return nil, fmt.Errorf("invalid renovate version rollout: '%s', must be version=percent", r)
+		}
+		total += percent
+		parsed = append(parsed, VersionRollout{Version: version, Percent: percent})
+	}
+	if total > 100 {
+		return nil, fmt.Errorf("renovate version rollouts are %g%% of the repos, must be at most 100%%", total)
+	}
+	return parsed, nil
+}
+
+// PickVersion returns the version repo runs on, or an empty string for the default version. The same repos are picked
+// every time and a repo keeps its version when the percent of its rollout is increased.
+func PickVersion(repo string, rollouts []VersionRollout) string {
+	if len(rollouts) == 0 {
+		return ""
+	}
+	bucket := Percentile(repo)
+	start := 0.0
+	for _, r := range rollouts {
+		if bucket < start+r.Percent {
+			return r.Version
+		}
+		start += r.Percent
+	}
+	return ""
+}
+
+// Percentile returns a number in [0, 100) that is always the same for key and evenly spread over keys.
+func Percentile(key string) float64 {
+	sum := sha256.Sum256([]byte(key))
+	return float64(binary.BigEndian.Uint64(sum[:8])%10000) / 100
+}
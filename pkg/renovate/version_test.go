@@ -0,0 +1,55 @@
+package renovate
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVersions(t *testing.T) {
+	versions, err := ParseVersions([]string{"next=/opt/renovate-next/bin/renovate", "38=/opt/renovate-38/bin/renovate"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"next": "/opt/renovate-next/bin/renovate", "38": "/opt/renovate-38/bin/renovate"}, versions)
+
+	for _, v := range []string{"next", "=renovate", "next=", "default=renovate", "a,b=renovate"} {
+		_, err = ParseVersions([]string{v})
+		assert.ErrorContains(t, err, "invalid renovate version", v)
+	}
+}
+
+func TestParseVersionRollouts(t *testing.T) {
+	rollouts, err := ParseVersionRollouts([]string{"next=5", "38=0.5"})
+	assert.NoError(t, err)
+	assert.Equal(t, []VersionRollout{{Version: "next", Percent: 5}, {Version: "38", Percent: 0.5}}, rollouts)
+
+	_, err = ParseVersionRollouts([]string{"next=101"})
+	assert.ErrorContains(t, err, "invalid renovate version rollout")
+	_, err = ParseVersionRollouts([]string{"next"})
+	assert.ErrorContains(t, err, "invalid renovate version rollout")
+	_, err = ParseVersionRollouts([]string{"next=60", "38=50"})
+	assert.ErrorContains(t, err, "at most 100%")
+}
+
+func TestPickVersion(t *testing.T) {
+	assert.Equal(t, "", PickVersion("project1/repo1", nil))
+	assert.Equal(t, "next", PickVersion("project1/repo1", []VersionRollout{{Version: "next", Percent: 100}}))
+
+	picked := map[string]int{}
+	five := map[string]string{}
+	for i := range 1000 {
+		repo := fmt.Sprintf("project1/repo%d", i)
+		v := PickVersion(repo, []VersionRollout{{Version: "next", Percent: 5}})
+		assert.Equal(t, v, PickVersion(repo, []VersionRollout{{Version: "next", Percent: 5}}))
+		picked[v]++
+		five[repo] = v
+	}
+	assert.InDelta(t, 50, picked["next"], 25)
+
+	// repos keep their version when the rollout grows
+	for repo, v := range five {
+		if v == "next" {
+			assert.Equal(t, "next", PickVersion(repo, []VersionRollout{{Version: "next", Percent: 20}}))
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package repocache
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fortnoxab/renovator/pkg/blob"
+	"github.com/fortnoxab/renovator/pkg/command"
+	"github.com/fortnoxab/renovator/pkg/renovate"
+	"github.com/urfave/cli/v2"
+)
+
+// ErrInvalid is returned when an archive is too big, expired or does not match its checksum.
+var ErrInvalid = errors.New("invalid repository cache")
+
+// Store keeps the renovate repository cache of each repo as an archive so it can be restored on any agent.
+// The archives are keyed by the target of the jobs so repos with the same name on different platforms don't share them.
+type Store struct {
+	Bucket blob.Bucket
+	// MaxSize is the maximum size of an archive in bytes, bigger caches are not stored.
+	MaxSize int64
+	// TTL is how long an archive is used after it was stored.
+	TTL time.Duration
+	// DefaultPlatform is the platform renovate uses for jobs without one, RENOVATE_PLATFORM or github if it is empty.
+	DefaultPlatform string
+}
+
+// NewFromContext returns nil if no repository cache store is configured.
+func NewFromContext(cCtx *cli.Context) (*Store, error) {
+	if cCtx.String("repo-cache") == "" {
+		return nil, nil
+	}
+	maxSize, err := command.ParseBytes(cCtx.String("repo-cache-max-size"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing --repo-cache-max-size, err: %w", err)
+	}
+	bucket, err := blob.New(cCtx.String("repo-cache"), blob.S3ConfigFromContext(cCtx))
+	if err != nil {
+		return nil, err
+	}
+	return &Store{
+		Bucket:          bucket,
+		MaxSize:         maxSize,
+		TTL:             cCtx.Duration("repo-cache-ttl"),
+		DefaultPlatform: os.Getenv("RENOVATE_PLATFORM"),
+	}, nil
+}
+
+// Dir returns the directory renovate keeps its repository cache in below cacheDir.
+func Dir(cacheDir string) string {
+	return filepath.Join(cacheDir, "renovate", "repository")
+}
+
+// Restore extracts the stored cache of the target of job into dir. It returns blob.ErrNotFound if there is none.
+func (s *Store) Restore(ctx context.Context, job renovate.Job, dir string) error {
+	repo := job.Target()
+	archive, err := s.get(ctx, key(job), repo)
+	if err != nil {
+		return err
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return fmt.Errorf("error reading cache of %s, err: %w", repo, err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading cache of %s, err: %w", repo, err)
+		}
+		if hdr.Typeflag != tar.TypeReg || !filepath.IsLocal(hdr.Name) {
+			return fmt.Errorf("unexpected file %s in cache of %s, err: %w", hdr.Name, repo, ErrInvalid)
+		}
+
+		path := filepath.Join(dir, hdr.Name)
+		err = os.MkdirAll(filepath.Dir(path), 0o755)
+		if err != nil {
+			return err
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr) // #nosec G110 the size is limited by MaxSize of the archive
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("error extracting %s from cache of %s, err: %w", hdr.Name, repo, err)
+		}
+	}
+}
+
+func (s *Store) get(ctx context.Context, key string, repo string) ([]byte, error) {
+	sum, err := s.Bucket.Get(ctx, checksumKey(key))
+	if err != nil {
+		return nil, err
+	}
+	expected, err := io.ReadAll(io.LimitReader(sum.Body, 128))
+	sum.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := s.Bucket.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Body.Close()
+
+	if s.TTL > 0 && time.Since(obj.ModTime) > s.TTL {
+		return nil, fmt.Errorf("cache of %s is from %s, err: %w", repo, obj.ModTime.Format(time.RFC3339), ErrInvalid)
+	}
+	if s.MaxSize > 0 && obj.Size > s.MaxSize {
+		return nil, fmt.Errorf("cache of %s is %d bytes, err: %w", repo, obj.Size, ErrInvalid)
+	}
+
+	r := obj.Body
+	if s.MaxSize > 0 {
+		r = io.NopCloser(io.LimitReader(obj.Body, s.MaxSize+1))
+	}
+	archive, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading cache of %s, err: %w", repo, err)
+	}
+	if s.MaxSize > 0 && int64(len(archive)) > s.MaxSize {
+		return nil, fmt.Errorf("cache of %s is bigger than %d bytes, err: %w", repo, s.MaxSize, ErrInvalid)
+	}
+	if checksum(archive) != strings.TrimSpace(string(expected)) {
+		return nil, fmt.Errorf("checksum of cache of %s does not match, err: %w", repo, ErrInvalid)
+	}
+	return archive, nil
+}
+
+// Save stores the cache files of the target of job in dir. Nothing is stored if there are none.
+func (s *Store) Save(ctx context.Context, job renovate.Job, dir string) error {
+	repo := job.Target()
+	platform := job.Platform
+	if platform == "" {
+		platform = s.DefaultPlatform
+	}
+	if platform == "" {
+		platform = "github"
+	}
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	files := 0
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil || !d.Type().IsRegular() || !isRepoCache(name, platform, job.Repo) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		err = tw.WriteHeader(&tar.Header{Name: filepath.ToSlash(name), Mode: 0o644, Size: info.Size(), ModTime: info.ModTime()})
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		files++
+		return err
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("error archiving cache of %s, err: %w", repo, err)
+	}
+	if files == 0 {
+		return nil
+	}
+
+	if s.MaxSize > 0 && int64(buf.Len()) > s.MaxSize {
+		return fmt.Errorf("cache of %s is %d bytes, err: %w", repo, buf.Len(), ErrInvalid)
+	}
+
+	sum := checksum(buf.Bytes())
+	err = s.Bucket.Put(ctx, key(job), bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		return fmt.Errorf("error uploading cache of %s, err: %w", repo, err)
+	}
+	err = s.Bucket.Put(ctx, checksumKey(key(job)), strings.NewReader(sum), int64(len(sum)))
+	if err != nil {
+		return fmt.Errorf("error uploading checksum of cache of %s, err: %w", repo, err)
+	}
+	return nil
+}
+
+// isRepoCache returns true for the cache file renovate writes for repo on platform, <platform>/<repo>.json.
+func isRepoCache(name string, platform string, repo string) bool {
+	return filepath.ToSlash(name) == platform+"/"+repo+".json"
+}
+
+func checksum(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// key returns <repo>.tar.gz for jobs on the default platform and <platform>/<endpoint checksum>/<repo>.tar.gz for others.
+func key(job renovate.Job) string {
+	name := job.Repo
+	if job.Platform != "" || job.Endpoint != "" {
+		name = path.Join(job.Platform, checksum([]byte(job.Endpoint))[:12], job.Repo)
+	}
+	return name + ".tar.gz"
+}
+
+func checksumKey(key string) string {
+	return key + ".sha256"
+}
@@ -0,0 +1,84 @@
+package repocache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fortnoxab/renovator/pkg/blob"
+	"github.com/fortnoxab/renovator/pkg/renovate"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveRestore(t *testing.T) {
+	ctx := context.Background()
+	bucket := &blob.Dir{Path: t.TempDir()}
+	s := &Store{Bucket: bucket, MaxSize: 1 << 20, TTL: time.Hour, DefaultPlatform: "bitbucket-server"}
+	repo1 := renovate.Job{Repo: "project1/repo1"}
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "bitbucket-server", "project1", "repo1.json"), `{"revision":13}`)
+	writeFile(t, filepath.Join(dir, "bitbucket-server", "project1", "repo2.json"), `{"revision":13}`)
+	writeFile(t, filepath.Join(dir, "github", "project1", "repo1.json"), `{"revision":14}`)
+
+	err := s.Restore(ctx, repo1, dir)
+	assert.ErrorIs(t, err, blob.ErrNotFound)
+
+	assert.NoError(t, s.Save(ctx, repo1, dir))
+	assert.FileExists(t, filepath.Join(bucket.Path, "project1", "repo1.tar.gz"))
+	assert.FileExists(t, filepath.Join(bucket.Path, "project1", "repo1.tar.gz.sha256"))
+
+	// nothing is stored for repos without cache
+	assert.NoError(t, s.Save(ctx, renovate.Job{Repo: "project1/repo3"}, dir))
+	assert.NoFileExists(t, filepath.Join(bucket.Path, "project1", "repo3.tar.gz"))
+
+	restored := t.TempDir()
+	assert.NoError(t, s.Restore(ctx, repo1, restored))
+	b, err := os.ReadFile(filepath.Join(restored, "bitbucket-server", "project1", "repo1.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"revision":13}`, string(b))
+	assert.NoFileExists(t, filepath.Join(restored, "bitbucket-server", "project1", "repo2.json"))
+	assert.NoFileExists(t, filepath.Join(restored, "github", "project1", "repo1.json"))
+
+	// a repo with the same name on another platform has its own cache
+	github := renovate.Job{Repo: "project1/repo1", Platform: "github", Endpoint: "https://api.github.com"}
+	assert.ErrorIs(t, s.Restore(ctx, github, t.TempDir()), blob.ErrNotFound)
+	assert.NoError(t, s.Save(ctx, github, dir))
+
+	restored = t.TempDir()
+	assert.NoError(t, s.Restore(ctx, github, restored))
+	b, err = os.ReadFile(filepath.Join(restored, "github", "project1", "repo1.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"revision":14}`, string(b))
+	assert.NoFileExists(t, filepath.Join(restored, "bitbucket-server", "project1", "repo1.json"))
+}
+
+func TestRestoreInvalid(t *testing.T) {
+	ctx := context.Background()
+	bucket := &blob.Dir{Path: t.TempDir()}
+	s := &Store{Bucket: bucket, TTL: time.Hour}
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "github", "org", "repo.json"), strings.Repeat("x", 1000))
+	job := renovate.Job{Repo: "org/repo"}
+	assert.NoError(t, s.Save(ctx, job, dir))
+
+	writeFile(t, filepath.Join(bucket.Path, "org", "repo.tar.gz.sha256"), "0000")
+	assert.ErrorIs(t, s.Restore(ctx, job, t.TempDir()), ErrInvalid)
+
+	assert.NoError(t, s.Save(ctx, job, dir))
+	old := time.Now().Add(-2 * time.Hour)
+	assert.NoError(t, os.Chtimes(filepath.Join(bucket.Path, "org", "repo.tar.gz"), old, old))
+	assert.ErrorIs(t, s.Restore(ctx, job, t.TempDir()), ErrInvalid)
+
+	s.MaxSize = 10
+	assert.ErrorIs(t, s.Save(ctx, job, dir), ErrInvalid)
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+}
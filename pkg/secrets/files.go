@@ -0,0 +1,49 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Files reads the secrets of a project from the files in Dir/<project>, the file name is the environment variable.
+// The project may be qualified by its platform, see renovate.Job.Scope.
+// It is meant for mounted kubernetes secrets.
+type Files struct {
+	Dir string
+}
+
+func (f *Files) Secrets(ctx context.Context, project string) (map[string]string, error) {
+	if project == "" || !filepath.IsLocal(project) {
+		return nil, nil
+	}
+
+	dir := filepath.Join(f.Dir, project)
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := map[string]string{}
+	for _, entry := range entries {
+		// kubernetes mounts secrets through hidden directories and symlinks
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if err := validName(entry.Name()); err != nil {
+			return nil, err
+		}
+		b, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		secrets[entry.Name()] = strings.TrimRight(string(b), "\r\n")
+	}
+	return secrets, nil
+}
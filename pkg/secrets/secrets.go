@@ -0,0 +1,78 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Source returns the secrets of a project by environment variable name.
+type Source interface {
+	Secrets(ctx context.Context, project string) (map[string]string, error)
+}
+
+// Sources merges the secrets of all sources, later sources take precedence.
+type Sources []Source
+
+func (s Sources) Secrets(ctx context.Context, project string) (map[string]string, error) {
+	secrets := map[string]string{}
+	for _, source := range s {
+		found, err := source.Secrets(ctx, project)
+		if err != nil {
+			return nil, err
+		}
+		maps.Copy(secrets, found)
+	}
+	return secrets, nil
+}
+
+// NewFromContext returns nil if no secret source is configured.
+func NewFromContext(cCtx *cli.Context) Source {
+	var sources Sources
+	if dir := cCtx.String("secrets-dir"); dir != "" {
+		sources = append(sources, &Files{Dir: dir})
+	}
+	if addr := cCtx.String("vault-addr"); addr != "" {
+		sources = append(sources, &Vault{
+			Address: addr,
+			Token:   cCtx.String("vault-token"),
+			Mount:   cCtx.String("vault-mount"),
+			Path:    cCtx.String("vault-path"),
+		})
+	}
+	if len(sources) == 0 {
+		return nil
+	}
+	return sources
+}
+
+// Environ returns secrets as KEY=VALUE sorted by key.
+func Environ(secrets map[string]string) []string {
+	var env []string
+	for _, key := range slices.Sorted(maps.Keys(secrets)) {
+		env = append(env, key+"="+secrets[key])
+	}
+	return env
+}
+
+// Values returns the values of secrets to redact from logs.
+func Values(secrets map[string]string) []string {
+	var values []string
+	for _, value := range secrets {
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func validName(name string) error {
+	if name == "" || strings.Trim(name, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789_") != "" {
+		return fmt.Errorf("invalid secret name '%s', it must be a valid environment variable name", name)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package secrets
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "project1", "..data"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "project1", "RENOVATE_TOKEN"), []byte("token1\n"), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "project1", "NPM_TOKEN"), []byte("npm1"), 0o600))
+
+	f := &Files{Dir: dir}
+	found, err := f.Secrets(context.Background(), "project1")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"RENOVATE_TOKEN": "token1", "NPM_TOKEN": "npm1"}, found)
+	assert.Equal(t, []string{"NPM_TOKEN=npm1", "RENOVATE_TOKEN=token1"}, Environ(found))
+
+	found, err = f.Secrets(context.Background(), "project2")
+	assert.NoError(t, err)
+	assert.Empty(t, found)
+
+	found, err = f.Secrets(context.Background(), "..")
+	assert.NoError(t, err)
+	assert.Empty(t, found)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "project1", "NOT-VALID"), []byte("x"), 0o600))
+	_, err = f.Secrets(context.Background(), "project1")
+	assert.Error(t, err)
+}
+
+func TestVault(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/kv/data/renovator/project1", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "vault-token", r.Header.Get("X-Vault-Token"))
+		_, _ = w.Write([]byte(`{"data":{"data":{"RENOVATE_TOKEN":"token1","MAVEN_PASSWORD":"pw"},"metadata":{"version":3}}}`))
+	})
+	mux.HandleFunc("/v1/kv/data/renovator/project2", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"errors":[]}`))
+	})
+	mux.HandleFunc("/v1/kv/data/renovator/project3", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	v := &Vault{Address: ts.URL + "/", Token: "vault-token", Mount: "kv", Path: "renovator"}
+	found, err := v.Secrets(context.Background(), "project1")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"RENOVATE_TOKEN": "token1", "MAVEN_PASSWORD": "pw"}, found)
+
+	found, err = v.Secrets(context.Background(), "project2")
+	assert.NoError(t, err)
+	assert.Empty(t, found)
+
+	_, err = v.Secrets(context.Background(), "project3")
+	assert.ErrorContains(t, err, "permission denied")
+}
+
+func TestSources(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "project1"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "project1", "RENOVATE_TOKEN"), []byte("old"), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "project1", "NPM_TOKEN"), []byte("npm1"), 0o600))
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data":{"data":{"RENOVATE_TOKEN":"new"}}}`))
+	}))
+	defer ts.Close()
+
+	s := Sources{&Files{Dir: dir}, &Vault{Address: ts.URL}}
+	found, err := s.Secrets(context.Background(), "project1")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"RENOVATE_TOKEN": "new", "NPM_TOKEN": "npm1"}, found)
+}
@@ -0,0 +1,92 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Vault reads the secrets of a project from the key value store version 2 of HashiCorp Vault or a compatible api.
+// The secret <Mount>/<Path>/<project> holds the environment variables of the project, the project may be qualified by
+// its platform, see renovate.Job.Scope.
+type Vault struct {
+	Address string
+	Token   string
+	// Mount is the mount of the key value store, defaults to secret.
+	Mount string
+	// Path is the path below the mount of the secrets of all projects.
+	Path   string
+	Client *http.Client
+}
+
+type vaultResponse struct {
+	Data struct {
+		Data map[string]interface{} `json:"data"`
+	} `json:"data"`
+}
+
+func (v *Vault) Secrets(ctx context.Context, project string) (map[string]string, error) {
+	if project == "" {
+		return nil, nil
+	}
+	mount := v.Mount
+	if mount == "" {
+		mount = "secret"
+	}
+	var segments []string
+	for _, segment := range strings.Split(project, "/") {
+		segments = append(segments, url.PathEscape(segment))
+	}
+	path := strings.Join(segments, "/")
+	if p := strings.Trim(v.Path, "/"); p != "" {
+		path = p + "/" + path
+	}
+	u := strings.TrimSuffix(v.Address, "/") + "/v1/" + strings.Trim(mount, "/") + "/data/" + path
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error reading secrets of %s from vault, err: %w", project, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("unexpected status code reading secrets of %s from vault: %d body: %s", project, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	data := &vaultResponse{}
+	err = json.NewDecoder(resp.Body).Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding secrets of %s from vault, err: %w", project, err)
+	}
+
+	secrets := make(map[string]string, len(data.Data.Data))
+	for name, value := range data.Data.Data {
+		if err := validName(name); err != nil {
+			return nil, err
+		}
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("secret %s of %s is not a string", name, project)
+		}
+		secrets[name] = s
+	}
+	return secrets, nil
+}
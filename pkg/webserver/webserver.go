@@ -2,9 +2,11 @@ package webserver
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/fortnoxab/ginprometheus"
@@ -17,6 +19,12 @@ import (
 type Webserver struct {
 	Port          string
 	EnableMetrics bool
+	// Routes registers additional handlers on the router.
+	Routes func(router *gin.Engine)
+	// AdminRoutes registers handlers that change state, they require the header "Authorization: Bearer <AdminToken>".
+	AdminRoutes func(router gin.IRoutes)
+	// AdminToken is the token of the admin routes, they are disabled without it.
+	AdminToken string
 }
 
 func (ws *Webserver) Init() *gin.Engine {
@@ -34,9 +42,28 @@ func (ws *Webserver) Init() *gin.Engine {
 	router.Use(ginlogrus.New(logrus.StandardLogger(), logIgnorePaths...), gin.Recovery())
 
 	pprof.Register(router)
+	if ws.Routes != nil {
+		ws.Routes(router)
+	}
+	if ws.AdminRoutes != nil {
+		ws.AdminRoutes(router.Group("", ws.requireAdminToken))
+	}
 	return router
 }
 
+func (ws *Webserver) requireAdminToken(c *gin.Context) {
+	if ws.AdminToken == "" {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin endpoints are disabled, start with --admin-token to enable them"})
+		return
+	}
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(ws.AdminToken)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid admin token"})
+		return
+	}
+	c.Next()
+}
+
 func (ws *Webserver) Start(ctx context.Context) {
 	srv := &http.Server{
 		ReadTimeout:       1 * time.Second,
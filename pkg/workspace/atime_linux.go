@@ -0,0 +1,20 @@
+package workspace
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// lastUsed returns the latest of the access and modification time of a file.
+func lastUsed(info os.FileInfo) time.Time {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return info.ModTime()
+	}
+	atime := time.Unix(stat.Atim.Sec, stat.Atim.Nsec)
+	if atime.After(info.ModTime()) {
+		return atime
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package workspace
+
+import (
+	"os"
+	"time"
+)
+
+func lastUsed(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
@@ -0,0 +1,211 @@
+package workspace
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Manager creates a directory per renovate run below Dir and shares only the cache directory between runs.
+type Manager struct {
+	Dir string
+	// ID is the agent the workspaces belong to, agents on the same host only remove their own workspaces.
+	ID string
+	// CacheDir is shared between runs, defaults to cache in Dir.
+	CacheDir string
+	// CacheMaxSize is the number of bytes the cache is evicted down to after a run, 0 is unlimited.
+	CacheMaxSize int64
+
+	// mu guards started, the time each workspace that is not removed yet was created.
+	mu      sync.Mutex
+	started map[*Workspace]time.Time
+	// evicting is set while the cache is evicted so runs finishing at the same time don't evict it concurrently.
+	evicting atomic.Bool
+}
+
+// Workspace is the directory of a single run.
+type Workspace struct {
+	Dir      string
+	CacheDir string
+
+	release func()
+}
+
+func (m *Manager) runsDir() string {
+	return filepath.Join(m.Dir, "runs", m.ID)
+}
+
+func (m *Manager) cacheDir() string {
+	if m.CacheDir != "" {
+		return m.CacheDir
+	}
+	return filepath.Join(m.Dir, "cache")
+}
+
+// Create creates the workspace of run id.
+func (m *Manager) Create(id string) (*Workspace, error) {
+	w := &Workspace{Dir: filepath.Join(m.runsDir(), id), CacheDir: m.cacheDir()}
+	m.mu.Lock()
+	if m.started == nil {
+		m.started = map[*Workspace]time.Time{}
+	}
+	m.started[w] = time.Now()
+	m.mu.Unlock()
+	w.release = func() {
+		m.mu.Lock()
+		delete(m.started, w)
+		m.mu.Unlock()
+	}
+
+	for _, dir := range []string{filepath.Join(w.Dir, "tmp"), w.CacheDir} {
+		err := os.MkdirAll(dir, 0o755)
+		if err != nil {
+			w.release()
+			return nil, fmt.Errorf("error creating workspace, err: %w", err)
+		}
+	}
+	return w, nil
+}
+
+// Cleanup removes the workspaces of the agent left by runs that did not finish, ex when the agent was killed.
+func (m *Manager) Cleanup() error {
+	err := os.RemoveAll(m.runsDir())
+	if err != nil {
+		return fmt.Errorf("error removing old workspaces, err: %w", err)
+	}
+	return nil
+}
+
+// CacheSize returns the size of the shared cache in bytes.
+func (m *Manager) CacheSize() (int64, error) {
+	return Size(m.cacheDir())
+}
+
+type cacheEntry struct {
+	path string
+	size int64
+	used time.Time
+}
+
+// EvictCache removes the least recently used files from the cache until it is below CacheMaxSize.
+// Running renovate could fail if files it uses are removed, so files used since the oldest running workspace was
+// created are kept even if the cache stays too big, they are evicted after a later run. It does nothing while another
+// eviction is running and returns the number of bytes removed.
+func (m *Manager) EvictCache() (int64, error) {
+	if m.CacheMaxSize <= 0 || !m.evicting.CompareAndSwap(false, true) {
+		return 0, nil
+	}
+	defer m.evicting.Store(false)
+
+	entries, total, err := m.scanCache()
+	if err != nil || total <= m.CacheMaxSize {
+		return 0, err
+	}
+	inUse := m.oldestRun()
+	slices.SortFunc(entries, func(a, b cacheEntry) int {
+		return a.used.Compare(b.used)
+	})
+	var removed int64
+	for _, e := range entries {
+		if total <= m.CacheMaxSize || !e.used.Before(inUse) {
+			break
+		}
+		if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+			return removed, fmt.Errorf("error evicting %s from cache, err: %w", e.path, err)
+		}
+		total -= e.size
+		removed += e.size
+	}
+	return removed, nil
+}
+
+// oldestRun returns when the oldest workspace that is not removed was created, or now if there are none.
+func (m *Manager) oldestRun() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	oldest := time.Now()
+	for _, started := range m.started {
+		if started.Before(oldest) {
+			oldest = started
+		}
+	}
+	return oldest
+}
+
+// scanCache returns the files in the cache and their total size.
+func (m *Manager) scanCache() ([]cacheEntry, int64, error) {
+	var entries []cacheEntry
+	var total int64
+	err := filepath.WalkDir(m.cacheDir(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		entries = append(entries, cacheEntry{path: path, size: info.Size(), used: lastUsed(info)})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("error reading cache, err: %w", err)
+	}
+	return entries, total, nil
+}
+
+// Env returns the environment making renovate use the workspace.
+func (w *Workspace) Env() []string {
+	return []string{
+		"RENOVATE_BASE_DIR=" + w.Dir,
+		"RENOVATE_CACHE_DIR=" + w.CacheDir,
+		"TMPDIR=" + filepath.Join(w.Dir, "tmp"),
+	}
+}
+
+// Remove removes the workspace and lets the cache files it used be evicted.
+func (w *Workspace) Remove() error {
+	if w.release != nil {
+		defer w.release()
+		w.release = nil
+	}
+	err := os.RemoveAll(w.Dir)
+	if err != nil {
+		return fmt.Errorf("error removing workspace %s, err: %w", w.Dir, err)
+	}
+	return nil
+}
+
+// Size returns the size in bytes of the files in dir.
+func Size(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,152 @@
+package workspace
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkspace(t *testing.T) {
+	m := &Manager{Dir: t.TempDir()}
+
+	w, err := m.Create("run1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"RENOVATE_BASE_DIR=" + filepath.Join(m.Dir, "runs", "run1"),
+		"RENOVATE_CACHE_DIR=" + filepath.Join(m.Dir, "cache"),
+		"TMPDIR=" + filepath.Join(m.Dir, "runs", "run1", "tmp"),
+	}, w.Env())
+	assert.DirExists(t, filepath.Join(w.Dir, "tmp"))
+	assert.DirExists(t, w.CacheDir)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(w.Dir, "repo"), []byte("1234"), 0o644))
+	size, err := Size(w.Dir)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), size)
+
+	assert.NoError(t, w.Remove())
+	assert.NoDirExists(t, w.Dir)
+	assert.DirExists(t, w.CacheDir)
+
+	_, err = m.Create("run2")
+	assert.NoError(t, err)
+	assert.NoError(t, m.Cleanup())
+	assert.NoDirExists(t, filepath.Join(m.Dir, "runs"))
+}
+
+func TestCleanupOwnWorkspaces(t *testing.T) {
+	dir := t.TempDir()
+	agent1 := &Manager{Dir: dir, ID: "agent1"}
+	agent2 := &Manager{Dir: dir, ID: "agent2"}
+
+	w1, err := agent1.Create("run1")
+	assert.NoError(t, err)
+	w2, err := agent2.Create("run2")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "runs", "agent1", "run1"), w1.Dir)
+
+	assert.NoError(t, agent1.Cleanup())
+	assert.NoDirExists(t, w1.Dir)
+	assert.DirExists(t, w2.Dir)
+}
+
+func TestEvictCache(t *testing.T) {
+	m := &Manager{Dir: t.TempDir(), CacheDir: t.TempDir(), CacheMaxSize: 25}
+
+	now := time.Now()
+	for i, name := range []string{"old", "middle", "new"} {
+		path := filepath.Join(m.CacheDir, "dir", name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		assert.NoError(t, os.WriteFile(path, make([]byte, 10), 0o644))
+		used := now.Add(time.Duration(i-3) * time.Hour)
+		assert.NoError(t, os.Chtimes(path, used, used))
+	}
+
+	removed, err := m.EvictCache()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), removed)
+	assert.NoFileExists(t, filepath.Join(m.CacheDir, "dir", "old"))
+	assert.FileExists(t, filepath.Join(m.CacheDir, "dir", "middle"))
+
+	size, err := m.CacheSize()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(20), size)
+}
+
+func TestEvictCacheKeepsFilesOfRuns(t *testing.T) {
+	m := &Manager{Dir: t.TempDir(), CacheMaxSize: 5}
+	w, err := m.Create("run1")
+	assert.NoError(t, err)
+
+	old := filepath.Join(w.CacheDir, "old")
+	assert.NoError(t, os.WriteFile(old, make([]byte, 10), 0o644))
+	used := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(old, used, used))
+	path := filepath.Join(w.CacheDir, "file")
+	assert.NoError(t, os.WriteFile(path, make([]byte, 10), 0o644))
+
+	// files used since the run started are kept
+	removed, err := m.EvictCache()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), removed)
+	assert.NoFileExists(t, old)
+	assert.FileExists(t, path, "evicted while a run uses the cache")
+
+	assert.NoError(t, w.Remove())
+	removed, err = m.EvictCache()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), removed)
+	assert.NoFileExists(t, path)
+}
+
+func TestCreateDuringEviction(t *testing.T) {
+	m := &Manager{Dir: t.TempDir(), CacheMaxSize: 5}
+	w, err := m.Create("run1")
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(w.CacheDir, "file"), make([]byte, 10), 0o644))
+
+	// a long running run keeps the cache too big while other runs finish and evict it
+	stop := make(chan struct{})
+	evicted := make(chan struct{})
+	go func() {
+		defer close(evicted)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				_, err := m.EvictCache()
+				assert.NoError(t, err)
+			}
+		}
+	}()
+
+	created := make(chan error)
+	go func() {
+		for i := 0; i < 10; i++ {
+			w, err := m.Create("run2")
+			if err == nil {
+				err = w.Remove()
+			}
+			if err != nil {
+				created <- err
+				return
+			}
+		}
+		created <- nil
+	}()
+
+	select {
+	case err := <-created:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Error("creating a workspace was blocked by the eviction of the cache")
+	}
+	close(stop)
+	<-evicted
+	assert.FileExists(t, filepath.Join(w.CacheDir, "file"))
+	assert.NoError(t, w.Remove())
+}